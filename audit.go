@@ -0,0 +1,177 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nats-io/go-nats"
+)
+
+// AuditEntry : a single datacenter mutation, entries are only ever
+// appended
+type AuditEntry struct {
+	ID           uint      `json:"id" gorm:"primary_key"`
+	DatacenterID uint      `json:"datacenter_id" sql:"index"`
+	Actor        string    `json:"actor" sql:"index"`
+	Subject      string    `json:"subject"`
+	Operation    string    `json:"operation"`
+	Changes      Map       `json:"changes" gorm:"type: jsonb not null default '{}'::jsonb"`
+	CreatedAt    time.Time `json:"created_at" sql:"index"`
+}
+
+// TableName : set AuditEntry's table name to be datacenter_audit
+func (AuditEntry) TableName() string {
+	return "datacenter_audit"
+}
+
+// AuditFilter : fields to query the audit trail by, entries are returned
+// by id in pages of up to limit entries following after_id
+type AuditFilter struct {
+	DatacenterID uint       `json:"datacenter_id"`
+	Actor        string     `json:"actor"`
+	From         *time.Time `json:"from"`
+	To           *time.Time `json:"to"`
+	AfterID      uint       `json:"after_id"`
+	Limit        int        `json:"limit"`
+}
+
+// audit : records a mutation of the entity on the given transaction
+func (e *Entity) audit(tx *gorm.DB, op string, before, after *Entity) error {
+	return e.auditChanges(tx, op, diff(before, after))
+}
+
+// auditChanges : records the given changes of the entity on the given
+// transaction
+func (e *Entity) auditChanges(tx *gorm.DB, op string, changes Map) error {
+	entry := AuditEntry{
+		DatacenterID: e.ID,
+		Actor:        e.Actor,
+		Subject:      e.subject,
+		Operation:    op,
+		Changes:      changes,
+	}
+
+	return dbError(tx.Create(&entry).Error)
+}
+
+// diff : returns the changed fields between two versions of an entity,
+// secret credentials are only flagged as changed
+func diff(before, after *Entity) Map {
+	changes := make(Map)
+	if after == nil {
+		return changes
+	}
+	if before == nil {
+		before = &Entity{}
+	}
+
+	change := func(field string, from, to interface{}) {
+		changes[field] = Map{"from": from, "to": to}
+	}
+
+	if before.Name != after.Name {
+		change("name", before.Name, after.Name)
+	}
+	if before.Type != after.Type {
+		change("type", before.Type, after.Type)
+	}
+	if before.OwnerID != after.OwnerID {
+		change("owner_id", before.OwnerID, after.OwnerID)
+	}
+	if before.GroupID != after.GroupID {
+		change("group_id", before.GroupID, after.GroupID)
+	}
+	if (before.DeletedAt == nil) != (after.DeletedAt == nil) {
+		change("deleted_at", before.DeletedAt, after.DeletedAt)
+	}
+
+	keys := make(map[string]bool)
+	for k := range before.Credentials {
+		keys[k] = true
+	}
+	for k := range after.Credentials {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		from, hadKey := before.Credentials[k]
+		to, hasKey := after.Credentials[k]
+		if hadKey == hasKey && reflect.DeepEqual(from, to) {
+			continue
+		}
+
+		if isEncrypted(before.Type, k) || isEncrypted(after.Type, k) {
+			changes["credentials."+k] = "changed"
+			continue
+		}
+
+		change("credentials."+k, from, to)
+	}
+
+	return changes
+}
+
+// findAudit : replies with a page of the audit entries matching the given
+// filter, of the datacenters visible to the caller
+func findAudit(ctx context.Context, msg *nats.Msg) {
+	var f AuditFilter
+	if err := json.Unmarshal(msg.Data, &f); err != nil {
+		fail(msg, ErrInvalidInput)
+		return
+	}
+
+	caller, err := requiredCallerOf(msg.Data)
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if f.Limit < 1 {
+		f.Limit = defaultLimit
+	}
+	if f.Limit > maxLimit {
+		f.Limit = maxLimit
+	}
+
+	entries := []AuditEntry{}
+	err = session(ctx, func(tx *gorm.DB) error {
+		q := tx.Order("datacenter_audit.id").Limit(f.Limit)
+		if !caller.isAdmin() {
+			// the entries of deleted datacenters are still visible, the
+			// ones of purged datacenters only to admins
+			table := Entity{}.TableName()
+			q = caller.scope(q.Joins("JOIN " + table + " ON " + table + ".id = datacenter_audit.datacenter_id")).Select("datacenter_audit.*")
+		}
+
+		if f.AfterID != 0 {
+			q = q.Where("datacenter_audit.id > ?", f.AfterID)
+		}
+		if f.DatacenterID != 0 {
+			q = q.Where("datacenter_audit.datacenter_id = ?", f.DatacenterID)
+		}
+		if f.Actor != "" {
+			q = q.Where("datacenter_audit.actor = ?", f.Actor)
+		}
+		if f.From != nil {
+			q = q.Where("datacenter_audit.created_at >= ?", f.From)
+		}
+		if f.To != nil {
+			q = q.Where("datacenter_audit.created_at <= ?", f.To)
+		}
+
+		return dbError(q.Find(&entries).Error)
+	})
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, entries)
+}
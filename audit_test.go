@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditDiff(t *testing.T) {
+	Convey("Scenario: diffing two versions of a datacenter", t, func() {
+		before := Entity{Name: "test", Type: "aws", Credentials: Map{"region": "eu-west-1", "secret_access_key": "enc:1:a:b"}}
+		after := Entity{Name: "renamed", Type: "aws", Credentials: Map{"region": "us-east-1", "secret_access_key": "enc:1:c:d"}}
+
+		changes := diff(&before, &after)
+		So(changes["name"], ShouldResemble, Map{"from": "test", "to": "renamed"})
+		So(changes["credentials.region"], ShouldResemble, Map{"from": "eu-west-1", "to": "us-east-1"})
+		So(changes["credentials.secret_access_key"], ShouldEqual, "changed")
+		So(changes, ShouldNotContainKey, "type")
+
+		Convey("Given the datacenter is created", func() {
+			changes := diff(nil, &after)
+			So(changes["type"], ShouldResemble, Map{"from": "", "to": "aws"})
+			So(changes["credentials.secret_access_key"], ShouldEqual, "changed")
+		})
+
+		Convey("Given the datacenter is deleted", func() {
+			So(len(diff(&before, nil)), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAuditHandler(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_audit")
+	setupPg("test_audit")
+	startHandler()
+
+	Convey("Scenario: auditing datacenter mutations", t, func() {
+		setupTestSuite()
+		db.Unscoped().Delete(AuditEntry{})
+
+		msg, err := n.Request("datacenter.set", []byte(`{"name":"audited","type":"fake","caller":{"user_id":1,"roles":["admin"],"service":"api","token":"secret"},"credentials":{"region":"eu-west-1","token":"secret"}}`), time.Second)
+		So(err, ShouldBeNil)
+		e := Entity{}
+		So(json.Unmarshal(msg.Data, &e), ShouldBeNil)
+		id := fmt.Sprint(e.ID)
+
+		_, err = n.Request("datacenter.set", []byte(`{"id":`+id+`,"actor":"john","caller":{"user_id":2,"roles":["admin"],"service":"api","token":"secret"},"credentials":{"token":"other"}}`), time.Second)
+		So(err, ShouldBeNil)
+		_, err = n.Request("datacenter.del", []byte(`{"id":`+id+`,"caller":{"user_id":2,"roles":["admin"],"service":"api","token":"secret"}}`), time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("Given we search by datacenter", func() {
+			entries := []AuditEntry{}
+			msg, err := n.Request("datacenter.audit.find", []byte(`{"datacenter_id":`+id+`}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &entries), ShouldBeNil)
+
+			So(len(entries), ShouldEqual, 3)
+			So(entries[0].Operation, ShouldEqual, "create")
+			So(entries[0].Actor, ShouldEqual, "user:1")
+			So(entries[0].Subject, ShouldEqual, "datacenter.set")
+			So(entries[0].Changes["credentials.token"], ShouldEqual, "changed")
+			So(entries[1].Operation, ShouldEqual, "update")
+			So(entries[1].Actor, ShouldEqual, "user:2")
+			So(entries[1].Changes, ShouldNotContainKey, "credentials.region")
+			So(entries[1].Changes["credentials.token"], ShouldEqual, "changed")
+			So(entries[2].Operation, ShouldEqual, "delete")
+			So(entries[2].Subject, ShouldEqual, "datacenter.del")
+		})
+
+		Convey("Given we search in pages", func() {
+			first := []AuditEntry{}
+			msg, err := n.Request("datacenter.audit.find", []byte(`{"datacenter_id":`+id+`,"limit":2}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &first), ShouldBeNil)
+			So(len(first), ShouldEqual, 2)
+
+			next := []AuditEntry{}
+			msg, err = n.Request("datacenter.audit.find", []byte(`{"datacenter_id":`+id+`,"limit":2,"after_id":`+fmt.Sprint(first[1].ID)+`}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &next), ShouldBeNil)
+			So(len(next), ShouldEqual, 1)
+			So(next[0].Operation, ShouldEqual, "delete")
+		})
+
+		Convey("Given the caller can't see the datacenter", func() {
+			entries := []AuditEntry{}
+			msg, err := n.Request("datacenter.audit.find", []byte(`{"datacenter_id":`+id+`,"caller":{"user_id":5,"service":"api","token":"secret"}}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 0)
+		})
+
+		Convey("Given we search by actor and time range", func() {
+			from, _ := json.Marshal(time.Now().Add(-time.Minute))
+			entries := []AuditEntry{}
+			msg, err := n.Request("datacenter.audit.find", []byte(`{"actor":"user:2","from":`+string(from)+`}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+
+			to, _ := json.Marshal(time.Now().Add(-time.Minute))
+			msg, err = n.Request("datacenter.audit.find", []byte(`{"actor":"user:2","to":`+string(to)+`}`), time.Second)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(msg.Data, &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/nats-io/go-nats"
+)
+
+// defaultPlaintext : credential keys stored in plaintext for datacenter
+// types without a classification, every datacenter was stored with it
+// before classifications could be configured
+var defaultPlaintext = []string{"region", "vdc", "username", "vcloud_url"}
+
+// typePlaintext : credential keys stored in plaintext by default for the
+// datacenter types consumers read without decrypting, on top of the
+// default ones
+var typePlaintext = map[string][]string{
+	"azure":  append([]string{"subscription_id", "environment"}, defaultPlaintext...),
+	"vcloud": append([]string{"external_network"}, defaultPlaintext...),
+}
+
+// plaintext : credential keys stored in plaintext for each datacenter
+// type loaded from ERNEST_CREDENTIALS_CONFIG, any other key is encrypted
+var plaintext = map[string][]string{}
+
+// Classification : describes which credential keys of a datacenter type
+// are stored encrypted
+type Classification struct {
+	Type      string   `json:"type"`
+	Plaintext []string `json:"plaintext"`
+	Encrypted []string `json:"encrypted"`
+	Default   string   `json:"default"`
+}
+
+// loadClassification : loads the plaintext credential keys per datacenter
+// type from a json file, overriding the defaults for the types it defines
+func loadClassification(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var c map[string][]string
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+
+	for t, keys := range c {
+		plaintext[t] = keys
+	}
+
+	return nil
+}
+
+// isEncrypted : determines if a credential key is stored encrypted for
+// the given datacenter type
+func isEncrypted(t, key string) bool {
+	return !contains(plaintextKeys(t), key)
+}
+
+// plaintextKeys : returns the credential keys stored in plaintext for the
+// given datacenter type
+func plaintextKeys(t string) []string {
+	if keys, ok := plaintext[t]; ok {
+		return keys
+	}
+	if keys, ok := typePlaintext[t]; ok {
+		return keys
+	}
+	return defaultPlaintext
+}
+
+// knownTypes : returns every datacenter type with a credential schema or
+// a classification
+func knownTypes() []string {
+	var types []string
+	for t := range schemas {
+		types = append(types, t)
+	}
+	for t := range plaintext {
+		if _, ok := schemas[t]; !ok {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// classify : returns the classification for the given datacenter type
+func classify(t string) Classification {
+	c := Classification{Type: t, Plaintext: []string{}, Encrypted: []string{}, Default: "encrypted"}
+
+	c.Plaintext = append(c.Plaintext, plaintextKeys(t)...)
+
+	if s, ok := schemas[t]; ok {
+		for _, k := range append(append([]string{}, s.Required...), s.Optional...) {
+			if isEncrypted(t, k) {
+				c.Encrypted = append(c.Encrypted, k)
+			}
+		}
+	}
+
+	sort.Strings(c.Plaintext)
+	sort.Strings(c.Encrypted)
+
+	return c
+}
+
+// classification : replies with the credential classification for the
+// requested datacenter type, or for every known type if none is given
+func classification(ctx context.Context, msg *nats.Msg) {
+	var input struct {
+		Type string `json:"type"`
+	}
+
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &input); err != nil {
+			fail(msg, ErrInvalidInput)
+			return
+		}
+	}
+
+	if input.Type != "" {
+		respond(msg, classify(input.Type))
+		return
+	}
+
+	types := knownTypes()
+	list := make([]Classification, len(types))
+	for i, t := range types {
+		list[i] = classify(t)
+	}
+
+	respond(msg, list)
+}
@@ -0,0 +1,55 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassification(t *testing.T) {
+	Convey("Scenario: classifying credential keys", t, func() {
+		Convey("Given a datacenter type without classification", func() {
+			So(isEncrypted("aws", "region"), ShouldBeFalse)
+			So(isEncrypted("azure", "subscription_id"), ShouldBeFalse)
+			So(isEncrypted("azure", "environment"), ShouldBeFalse)
+			So(isEncrypted("azure", "client_secret"), ShouldBeTrue)
+			So(isEncrypted("vcloud", "vcloud_url"), ShouldBeFalse)
+			So(isEncrypted("vcloud", "external_network"), ShouldBeFalse)
+			So(isEncrypted("vcloud", "password"), ShouldBeTrue)
+			So(isEncrypted("other", "password"), ShouldBeTrue)
+		})
+
+		Convey("Given a classification is requested for a type", func() {
+			c := classify("aws")
+			So(c.Plaintext, ShouldResemble, []string{"region", "username", "vcloud_url", "vdc"})
+			So(c.Encrypted, ShouldResemble, []string{"access_key_id", "secret_access_key"})
+			So(c.Default, ShouldEqual, "encrypted")
+		})
+
+		Convey("Given every classification is requested", func() {
+			So(knownTypes(), ShouldResemble, []string{"aws", "azure", "fake", "vcloud"})
+		})
+	})
+
+	Convey("Scenario: loading the classification from a file", t, func() {
+		f, err := ioutil.TempFile("", "classification")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+
+		_, err = f.WriteString(`{"custom":["zone"]}`)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		err = loadClassification(f.Name())
+		defer delete(plaintext, "custom")
+		So(err, ShouldBeNil)
+		So(isEncrypted("custom", "zone"), ShouldBeFalse)
+		So(isEncrypted("custom", "token"), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,208 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package client provides typed access to the datacenter store through
+// its nats api.
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// APIVersion : version of the request envelope used by the client
+const APIVersion = "2"
+
+// DefaultTimeout : time to wait for a reply of the store by default
+const DefaultTimeout = time.Second * 5
+
+// Client : datacenter store client
+type Client struct {
+	seq     uint64
+	conn    *nats.Conn
+	timeout time.Duration
+	keys    Unwrapper
+	caller  *Caller
+
+	mu        sync.Mutex
+	plaintext map[string][]string
+}
+
+// Option : configures a client
+type Option func(*Client)
+
+// WithTimeout : sets the time to wait for a reply of the store
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithKeys : decrypts the credentials of every datacenter returned with
+// the given keys
+func WithKeys(k Unwrapper) Option {
+	return func(c *Client) {
+		c.keys = k
+	}
+}
+
+// WithCaller : sends the given caller on every request
+func WithCaller(caller *Caller) Option {
+	return func(c *Client) {
+		c.caller = caller
+	}
+}
+
+// New : creates a client requesting the store through the given nats
+// connection
+func New(conn *nats.Conn, opts ...Option) *Client {
+	c := Client{
+		conn:      conn,
+		timeout:   DefaultTimeout,
+		plaintext: make(map[string][]string),
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Get : returns the datacenter with the given id
+func (c *Client) Get(id uint) (*Datacenter, error) {
+	return c.get(reference{ID: id})
+}
+
+// GetByName : returns the datacenter with the given name
+func (c *Client) GetByName(name string) (*Datacenter, error) {
+	return c.get(reference{Name: name})
+}
+
+// Find : returns the datacenters matching the given filter
+func (c *Client) Find(f Filter) ([]Datacenter, error) {
+	var list []Datacenter
+
+	if f.paged() {
+		page, err := c.FindPage(f)
+		if err != nil {
+			return nil, err
+		}
+		return page.Items, nil
+	}
+
+	if err := c.request("datacenter.find", f.input(c.caller), &list); err != nil {
+		return nil, err
+	}
+
+	return list, c.decryptAll(list)
+}
+
+// FindPage : returns a page of the datacenters matching the given filter,
+// the next page is requested by setting After to the Next cursor
+func (c *Client) FindPage(f Filter) (*Page, error) {
+	var page Page
+
+	if f.Limit < 1 {
+		f.Limit = DefaultLimit
+	}
+
+	if err := c.request("datacenter.find", f.input(c.caller), &page); err != nil {
+		return nil, err
+	}
+
+	return &page, c.decryptAll(page.Items)
+}
+
+// Set : creates the given datacenter, or updates it if it has an id,
+// returning the stored datacenter
+func (c *Client) Set(d *Datacenter) (*Datacenter, error) {
+	return c.set(d, 0)
+}
+
+// SetVersion : updates the given datacenter, failing with a conflict
+// error if it was modified after the expected version
+func (c *Client) SetVersion(d *Datacenter, expected uint) (*Datacenter, error) {
+	return c.set(d, expected)
+}
+
+// Delete : deletes the datacenter with the given id
+func (c *Client) Delete(id uint) error {
+	var status struct {
+		Status string `json:"status"`
+	}
+
+	return c.request("datacenter.del", reference{ID: id, Caller: c.caller}, &status)
+}
+
+func (c *Client) get(r reference) (*Datacenter, error) {
+	var d Datacenter
+
+	r.Caller = c.caller
+	if err := c.request("datacenter.get", r, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, c.decrypt(&d)
+}
+
+func (c *Client) set(d *Datacenter, expected uint) (*Datacenter, error) {
+	var stored Datacenter
+
+	input := setRequest{
+		ID:          d.ID,
+		Name:        d.Name,
+		Type:        d.Type,
+		OwnerID:     d.OwnerID,
+		GroupID:     d.GroupID,
+		Credentials: d.Credentials,
+		Expected:    expected,
+		Caller:      c.caller,
+	}
+
+	if err := c.request("datacenter.set", input, &stored); err != nil {
+		return nil, err
+	}
+
+	return &stored, c.decrypt(&stored)
+}
+
+// request : requests the subject with the input wrapped on a versioned
+// envelope, decoding the reply data on v or returning its error
+func (c *Client) request(subject string, input, v interface{}) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(request{
+		APIVersion: APIVersion,
+		RequestID:  strconv.FormatUint(atomic.AddUint64(&c.seq, 1), 10),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.conn.Request(subject, body, c.timeout)
+	if err != nil {
+		return err
+	}
+
+	var res response
+	if err := json.Unmarshal(msg.Data, &res); err != nil {
+		return err
+	}
+
+	if res.Error != nil {
+		return res.Error
+	}
+
+	return json.Unmarshal(res.Data, v)
+}
@@ -0,0 +1,183 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	aes "github.com/ernestio/crypto/aes"
+	"github.com/ernestio/datacenter-store/secrets"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testKey = "mMYlPIvI11z20H1BnBmB223355667788"
+const testDataKey = "aAbBcCdDeEfFgGhHiIjJkKlLmMnNoOpP"
+
+func connect(t *testing.T) *nats.Conn {
+	uri := os.Getenv("NATS_URI")
+	if uri == "" {
+		uri = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+// received : data of the last request received by a store, written by
+// the nats handler of its subscription
+type received struct {
+	mu   sync.Mutex
+	data json.RawMessage
+}
+
+func (r *received) last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.data)
+}
+
+// store : replies on the subject with the given data or error, recording
+// the data of the last request
+func store(conn *nats.Conn, subject, data, err string) (*received, *nats.Subscription) {
+	last := &received{}
+
+	sub, _ := conn.Subscribe(subject, func(msg *nats.Msg) {
+		var req request
+		_ = json.Unmarshal(msg.Data, &req)
+		last.mu.Lock()
+		last.data = req.Data
+		last.mu.Unlock()
+
+		res := `{"api_version":"2","request_id":"` + req.RequestID + `","data":` + data + `}`
+		if err != "" {
+			res = `{"api_version":"2","request_id":"` + req.RequestID + `","error":` + err + `}`
+		}
+		_ = conn.Publish(msg.Reply, []byte(res))
+	})
+
+	return last, sub
+}
+
+func encrypted(t *testing.T, s string) string {
+	wrapped, err := aes.New().Encrypt(testDataKey, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := aes.New().Encrypt(s, testDataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secrets.Prefix + "1:" + wrapped + ":" + value
+}
+
+func TestClient(t *testing.T) {
+	conn := connect(t)
+	defer conn.Close()
+
+	Convey("Scenario: getting a datacenter", t, func() {
+		last, sub := store(conn, "datacenter.get", `{"id":1,"name":"test","type":"fake","version":3,"created_at":"2017-08-01T10:00:00Z"}`, "")
+		defer func() { _ = sub.Unsubscribe() }()
+		c := New(conn, WithCaller(&Caller{UserID: 2}))
+
+		d, err := c.Get(1)
+		So(err, ShouldBeNil)
+		So(d.Name, ShouldEqual, "test")
+		So(d.Version, ShouldEqual, 3)
+		So(d.CreatedAt.Year(), ShouldEqual, 2017)
+		So(last.last(), ShouldEqual, `{"id":1,"caller":{"user_id":2}}`)
+	})
+
+	Convey("Scenario: decoding errors", t, func() {
+		_, get := store(conn, "datacenter.get", "", `{"code":"404","message":"Not found","retryable":false}`)
+		defer func() { _ = get.Unsubscribe() }()
+		_, set := store(conn, "datacenter.set", "", `{"code":"422","message":"Invalid credentials","field":"credentials.region","retryable":false,"fields":[{"field":"credentials.region","message":"is required"}]}`)
+		defer func() { _ = set.Unsubscribe() }()
+		c := New(conn)
+
+		_, err := c.GetByName("unknown")
+		So(IsNotFound(err), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "Not found")
+
+		_, err = c.Set(&Datacenter{Name: "invalid", Type: "aws"})
+		So(IsInvalid(err), ShouldBeTrue)
+		So(err.(*Error).Fields[0].Field, ShouldEqual, "credentials.region")
+	})
+
+	Convey("Scenario: finding datacenters", t, func() {
+		last, sub := store(conn, "datacenter.find", `[{"id":1,"name":"a"},{"id":2,"name":"b"}]`, "")
+		defer func() { _ = sub.Unsubscribe() }()
+		c := New(conn)
+
+		list, err := c.Find(Filter{IDs: []uint{1, 2}, Type: "fake"})
+		So(err, ShouldBeNil)
+		So(len(list), ShouldEqual, 2)
+		So(last.last(), ShouldEqual, `{"type":"fake","ids":["1","2"]}`)
+	})
+
+	Convey("Scenario: timing out", t, func() {
+		c := New(conn, WithTimeout(time.Millisecond*50))
+
+		err := c.Delete(1)
+		So(err, ShouldEqual, nats.ErrTimeout)
+	})
+
+	Convey("Scenario: decrypting credentials", t, func() {
+		_, classification := store(conn, "datacenter.credentials", `{"type":"aws","plaintext":["region"],"encrypted":[],"default":"encrypted"}`, "")
+		defer func() { _ = classification.Unsubscribe() }()
+
+		secret := encrypted(t, "secret")
+		legacy, err := aes.New().Encrypt("key-id", testKey)
+		So(err, ShouldBeNil)
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"id":          1,
+			"type":        "aws",
+			"credentials": map[string]string{"region": "eu-west-1", "secret_access_key": secret, "access_key_id": legacy},
+		})
+		_, get := store(conn, "datacenter.get", string(body), "")
+		defer func() { _ = get.Unsubscribe() }()
+
+		Convey("Given the client has the key", func() {
+			d, err := New(conn, WithKeys(Keys{"1": testKey})).Get(1)
+			So(err, ShouldBeNil)
+			So(d.Credentials["region"], ShouldEqual, "eu-west-1")
+			So(d.Credentials["secret_access_key"], ShouldEqual, "secret")
+			So(d.Credentials["access_key_id"], ShouldEqual, "key-id")
+		})
+
+		Convey("Given the client has no keys", func() {
+			d, err := New(conn).Get(1)
+			So(err, ShouldBeNil)
+			So(d.Credentials["secret_access_key"], ShouldEqual, secret)
+		})
+
+		Convey("Given the client has an unknown key", func() {
+			_, err := New(conn, WithKeys(Keys{"2": testKey})).Get(1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Given the client finds redacted datacenters", func() {
+			_, find := store(conn, "datacenter.find", `[{"id":1,"type":"aws","credentials":{"region":"eu-west-1","secret_access_key":"****cret"},"encrypted_fields":["secret_access_key"]}]`, "")
+			defer func() { _ = find.Unsubscribe() }()
+
+			list, err := New(conn, WithKeys(Keys{"1": testKey})).Find(Filter{Redact: true})
+			So(err, ShouldBeNil)
+			So(len(list), ShouldEqual, 1)
+			So(list[0].Credentials["region"], ShouldEqual, "eu-west-1")
+			So(list[0].Credentials["secret_access_key"], ShouldEqual, "****cret")
+		})
+	})
+}
@@ -0,0 +1,120 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package client
+
+import (
+	"errors"
+
+	aes "github.com/ernestio/crypto/aes"
+	"github.com/ernestio/datacenter-store/secrets"
+)
+
+// Unwrapper : decrypts the data keys credential values are encrypted with
+type Unwrapper = secrets.Unwrapper
+
+// Keys : keys the store encrypts data keys with, by id. Values encrypted
+// before keys were versioned are decrypted with the key "1"
+type Keys map[string]string
+
+// Unwrap : decrypts a data key encrypted with the given key
+func (k Keys) Unwrap(id, wrapped string) (string, error) {
+	key, ok := k[id]
+	if !ok {
+		return "", errors.New("unknown key " + id)
+	}
+	return aes.New().Decrypt(wrapped, key)
+}
+
+// decrypt : decrypts the credentials of the datacenter if the client has
+// keys, legacy values are only decrypted for the keys stored encrypted.
+// Keys on its encrypted fields were redacted by the store, so they're
+// left masked
+func (c *Client) decrypt(d *Datacenter) error {
+	if c.keys == nil || len(d.Credentials) == 0 {
+		return nil
+	}
+
+	var plaintext []string
+
+	for k, v := range d.Credentials {
+		s, ok := v.(string)
+		if !ok || s == "" || contains(d.Encrypted, k) {
+			continue
+		}
+
+		if !secrets.Versioned(s) {
+			if plaintext == nil {
+				var err error
+				if plaintext, err = c.plaintextKeys(d.Type); err != nil {
+					return err
+				}
+			}
+			if contains(plaintext, k) {
+				continue
+			}
+		}
+
+		plain, err := secrets.Decrypt(s, c.keys)
+		if err != nil {
+			return errors.New("could not decrypt credentials." + k + ": " + err.Error())
+		}
+		d.Credentials[k] = plain
+	}
+
+	return nil
+}
+
+func (c *Client) decryptAll(list []Datacenter) error {
+	for i := range list {
+		if err := c.decrypt(&list[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plaintextKeys : returns the credential keys stored in plaintext for
+// the datacenter type, as classified by the store
+func (c *Client) plaintextKeys(t string) ([]string, error) {
+	c.mu.Lock()
+	keys, ok := c.plaintext[t]
+	c.mu.Unlock()
+	if ok {
+		return keys, nil
+	}
+
+	var classification struct {
+		Plaintext []string `json:"plaintext"`
+	}
+
+	input := struct {
+		Type   string  `json:"type"`
+		Caller *Caller `json:"caller,omitempty"`
+	}{t, c.caller}
+
+	if err := c.request("datacenter.credentials", input, &classification); err != nil {
+		return nil, err
+	}
+
+	keys = classification.Plaintext
+	if keys == nil {
+		keys = []string{}
+	}
+
+	c.mu.Lock()
+	c.plaintext[t] = keys
+	c.mu.Unlock()
+
+	return keys, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package client
+
+// Error : structured error replied by the store
+type Error struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Field     string       `json:"field,omitempty"`
+	Retryable bool         `json:"retryable"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError : describes a single field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error : returns the error string
+func (e *Error) Error() string {
+	if e.Field != "" {
+		return e.Message + ": " + e.Field
+	}
+	return e.Message
+}
+
+// IsNotFound : determines if the error means the datacenter does not exist
+func IsNotFound(err error) bool {
+	return code(err) == "404"
+}
+
+// IsConflict : determines if the error means the datacenter already
+// exists or was modified after the expected version
+func IsConflict(err error) bool {
+	return code(err) == "409"
+}
+
+// IsInvalid : determines if the error means the request or the given
+// credentials are not valid
+func IsInvalid(err error) bool {
+	c := code(err)
+	return c == "400" || c == "422"
+}
+
+// IsRetryable : determines if the request can be retried
+func IsRetryable(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Retryable
+}
+
+func code(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ""
+}
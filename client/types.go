@@ -0,0 +1,118 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// DefaultLimit : size of the pages requested when no limit is set
+const DefaultLimit = 100
+
+// Datacenter : datacenter stored on the store
+type Datacenter struct {
+	ID          uint                   `json:"id"`
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	OwnerID     uint                   `json:"owner_id"`
+	GroupID     uint                   `json:"group_id"`
+	Credentials map[string]interface{} `json:"credentials"`
+	Encrypted   []string               `json:"encrypted_fields,omitempty"`
+	Version     uint                   `json:"version"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	DeletedAt   *time.Time             `json:"deleted_at,omitempty"`
+}
+
+// Caller : user performing the requests through the given service,
+// datacenters are scoped to the ones it owns or share a group with
+type Caller struct {
+	UserID   uint     `json:"user_id"`
+	GroupIDs []uint   `json:"group_ids,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	Token    string   `json:"token,omitempty"`
+}
+
+// Filter : fields datacenters are searched by, empty fields are ignored
+type Filter struct {
+	IDs            []uint            `json:"-"`
+	Names          []string          `json:"names,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Type           string            `json:"type,omitempty"`
+	Types          []string          `json:"types,omitempty"`
+	NamePrefix     string            `json:"name_prefix,omitempty"`
+	NameContains   string            `json:"name_contains,omitempty"`
+	NameGlob       string            `json:"name_glob,omitempty"`
+	Credentials    map[string]string `json:"credentials,omitempty"`
+	CreatedAfter   *time.Time        `json:"created_after,omitempty"`
+	CreatedBefore  *time.Time        `json:"created_before,omitempty"`
+	UpdatedAfter   *time.Time        `json:"updated_after,omitempty"`
+	UpdatedBefore  *time.Time        `json:"updated_before,omitempty"`
+	IncludeDeleted bool              `json:"include_deleted,omitempty"`
+	Redact         bool              `json:"redact,omitempty"`
+	OrderBy        string            `json:"order_by,omitempty"`
+	Limit          int               `json:"limit,omitempty"`
+	Offset         int               `json:"offset,omitempty"`
+	After          string            `json:"after,omitempty"`
+}
+
+// Page : page of datacenters
+type Page struct {
+	Items  []Datacenter `json:"items"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+	Next   string       `json:"next"`
+}
+
+func (f Filter) paged() bool {
+	return f.Limit > 0 || f.Offset > 0 || f.After != ""
+}
+
+func (f Filter) input(caller *Caller) interface{} {
+	var ids []string
+	for _, id := range f.IDs {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+
+	return struct {
+		Filter
+		IDs    []string `json:"ids,omitempty"`
+		Caller *Caller  `json:"caller,omitempty"`
+	}{f, ids, caller}
+}
+
+type reference struct {
+	ID     uint    `json:"id,omitempty"`
+	Name   string  `json:"name,omitempty"`
+	Caller *Caller `json:"caller,omitempty"`
+}
+
+type setRequest struct {
+	ID          uint                   `json:"id,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	OwnerID     uint                   `json:"owner_id,omitempty"`
+	GroupID     uint                   `json:"group_id,omitempty"`
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+	Expected    uint                   `json:"expected_version,omitempty"`
+	Caller      *Caller                `json:"caller,omitempty"`
+}
+
+type request struct {
+	APIVersion string          `json:"api_version"`
+	RequestID  string          `json:"request_id"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type response struct {
+	APIVersion string          `json:"api_version"`
+	RequestID  string          `json:"request_id"`
+	Data       json.RawMessage `json:"data"`
+	Error      *Error          `json:"error"`
+}
@@ -0,0 +1,53 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/ernestio/datacenter-store/secrets"
+)
+
+// needsRekey : determines if a value is not encrypted with the given
+// primary key
+func needsRekey(s, primary string) bool {
+	return !secrets.Versioned(s) || secrets.KeyID(s) != primary
+}
+
+// crypt : encrypts a value with a random data key, which is encrypted
+// with the primary key and stored alongside the value as
+// enc:<key id>:<encrypted data key>:<encrypted value>
+func crypt(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	defer observeCrypto("encrypt", time.Now())
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	dataKey := base64.StdEncoding.EncodeToString(raw)
+
+	id, wrapped, err := currentKeyProvider().Wrap(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return secrets.Encrypt(s, id, wrapped, dataKey)
+}
+
+// decrypt : decrypts a value encrypted either with crypt or with the
+// legacy key
+func decrypt(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	defer observeCrypto("decrypt", time.Now())
+
+	return secrets.Decrypt(s, currentKeyProvider())
+}
@@ -0,0 +1,155 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	aes "github.com/ernestio/crypto/aes"
+	"github.com/ernestio/datacenter-store/secrets"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const oldTestKey = "mMYlPIvI11z20H1BnBmB223355667788"
+const newTestKey = "aAbBcCdDeEfFgGhHiIjJkKlLmMnNoOpP"
+
+func TestCrypto(t *testing.T) {
+	Convey("Scenario: encrypting credentials", t, func() {
+		defer func(p KeyProvider) { keyProvider = p }(currentKeyProvider())
+		keyProvider, _ = NewKeyring("1", oldTestKey, nil)
+
+		Convey("Given a value is encrypted", func() {
+			x, err := crypt("secret")
+			So(err, ShouldBeNil)
+			So(strings.HasPrefix(x, "enc:1:"), ShouldBeTrue)
+			So(secrets.KeyID(x), ShouldEqual, "1")
+			So(needsRekey(x, "1"), ShouldBeFalse)
+
+			Convey("Then it should be decrypted", func() {
+				plain, err := decrypt(x)
+				So(err, ShouldBeNil)
+				So(plain, ShouldEqual, "secret")
+			})
+		})
+
+		Convey("Given a value encrypted with the legacy key", func() {
+			x, err := aes.New().Encrypt("secret", oldTestKey)
+			So(err, ShouldBeNil)
+			So(needsRekey(x, "1"), ShouldBeTrue)
+
+			plain, err := decrypt(x)
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "secret")
+		})
+
+		Convey("Given the primary key is rotated", func() {
+			x, _ := crypt("secret")
+			keyProvider, _ = NewKeyring("2", newTestKey, map[string]string{"1": oldTestKey})
+
+			So(needsRekey(x, "2"), ShouldBeTrue)
+			plain, err := decrypt(x)
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "secret")
+
+			y, _ := crypt("secret")
+			So(secrets.KeyID(y), ShouldEqual, "2")
+		})
+
+		Convey("Given a value encrypted with an unknown key", func() {
+			_, err := decrypt("enc:9:foo:bar")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRekey(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_rekey")
+	setupPg("test_rekey")
+	startHandler()
+
+	admin := `{"user_id":3,"roles":["admin"],"service":"api","token":"secret"}`
+
+	Convey("Scenario: rotating the encryption key", t, func() {
+		setupTestSuite()
+		defer func(p KeyProvider) { keyProvider = p }(currentKeyProvider())
+		keyProvider, _ = NewKeyring("1", oldTestKey, nil)
+
+		legacy, _ := aes.New().Encrypt("test-key", oldTestKey)
+		db.Create(&Entity{Name: "legacy", Type: "aws", Credentials: Map{"region": "eu-west-1", "secret_access_key": legacy}})
+
+		Convey("Given a new primary key is configured", func() {
+			keyProvider, _ = NewKeyring("2", newTestKey, map[string]string{"1": oldTestKey})
+
+			Convey("Then every datacenter should be re-encrypted with it", func() {
+				msg, err := n.Request("datacenter.rekey", []byte(`{"batch_size":1,"caller":`+admin+`}`), time.Second*5)
+				So(err, ShouldBeNil)
+
+				res := RekeyResult{}
+				So(json.Unmarshal(msg.Data, &res), ShouldBeNil)
+				So(res.KeyID, ShouldEqual, "2")
+				So(res.Rekeyed, ShouldEqual, 1)
+				So(res.Done, ShouldBeTrue)
+
+				stored := Entity{}
+				db.Where("name = ?", "legacy").First(&stored)
+				x := stored.Credentials["secret_access_key"].(string)
+				So(secrets.KeyID(x), ShouldEqual, "2")
+				So(stored.Credentials["region"], ShouldEqual, "eu-west-1")
+				So(stored.Version, ShouldEqual, 2)
+
+				plain, err := decrypt(x)
+				So(err, ShouldBeNil)
+				So(plain, ShouldEqual, "test-key")
+
+				var entries []AuditEntry
+				db.Where("datacenter_id = ? AND operation = ?", stored.ID, "rekey").Find(&entries)
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].Changes["credentials.secret_access_key"], ShouldEqual, "rekeyed")
+			})
+
+			Convey("Then callers other than admins are rejected", func() {
+				msg, err := n.Request("datacenter.rekey", []byte(`{"caller":{"user_id":1,"group_ids":[10],"roles":["editor"],"service":"api","token":"secret"}}`), time.Second*5)
+				So(err, ShouldBeNil)
+				So(string(msg.Data), ShouldEqual, string(ErrForbidden.Encoded()))
+
+				msg, err = n.Request("datacenter.rekey", []byte(`{}`), time.Second*5)
+				So(err, ShouldBeNil)
+				So(string(msg.Data), ShouldEqual, string(ErrUnauthorized.Encoded()))
+
+				stored := Entity{}
+				db.Where("name = ?", "legacy").First(&stored)
+				So(stored.Version, ShouldEqual, 1)
+			})
+
+			Convey("Then datacenters that can't be decrypted are reported and left untouched", func() {
+				db.Create(&Entity{Name: "broken", Type: "aws", Credentials: Map{"secret_access_key": "enc:9:foo:bar"}})
+
+				msg, err := n.Request("datacenter.rekey", []byte(`{"batch_size":1,"caller":`+admin+`}`), time.Second*5)
+				So(err, ShouldBeNil)
+
+				res := RekeyResult{}
+				So(json.Unmarshal(msg.Data, &res), ShouldBeNil)
+				So(res.Rekeyed, ShouldEqual, 1)
+				So(res.Done, ShouldBeTrue)
+				So(len(res.Failed), ShouldEqual, 1)
+				So(res.Failed[0].Error, ShouldContainSubstring, "credentials.secret_access_key")
+
+				broken := Entity{}
+				db.Where("name = ?", "broken").First(&broken)
+				So(broken.Credentials["secret_access_key"], ShouldEqual, "enc:9:foo:bar")
+				So(broken.Version, ShouldEqual, 1)
+			})
+		})
+	})
+}
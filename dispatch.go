@@ -0,0 +1,110 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// localInbox : prefix of the reply subjects of requests not received
+// through nats
+const localInbox = "_INBOX.local."
+
+// inboxReply : reply of a datacenter subject handler to a request not
+// received through nats
+type inboxReply struct {
+	body     []byte
+	err      *Error
+	done     chan struct{}
+	deadline time.Time
+}
+
+// inboxes : replies of the requests being handled without nats, by the
+// reply subject given to their handlers
+var inboxes sync.Map
+var inboxSeq uint64
+
+// call : runs the handler of the given subject with the json encoded
+// input, returning its reply body and the error it failed with. The
+// request times out on the context deadline if it's earlier than the
+// request timeout
+func call(ctx context.Context, subject string, input interface{}) ([]byte, *Error) {
+	h := serve(subject)
+	if h == nil {
+		return ErrNotFound.Encoded(), ErrNotFound
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return ErrInvalidInput.Encoded(), ErrInvalidInput
+	}
+
+	res := &inboxReply{done: make(chan struct{})}
+	res.deadline, _ = ctx.Deadline()
+	inbox := localInbox + strconv.FormatUint(atomic.AddUint64(&inboxSeq, 1), 10)
+	inboxes.Store(inbox, res)
+
+	h(&nats.Msg{Subject: subject, Reply: inbox, Data: data})
+
+	// the reply is stored by the worker handling the request, which
+	// replies with a timeout error itself once the request times out
+	select {
+	case <-res.done:
+	case <-ctx.Done():
+		return ErrTimeout.Encoded(), ErrTimeout
+	case <-time.After(requestTimeout + time.Second):
+		return ErrTimeout.Encoded(), ErrTimeout
+	}
+
+	return res.body, res.err
+}
+
+// callDeadline : returns the deadline of the context a request not
+// received through nats was called with, if it has one
+func callDeadline(msg *nats.Msg) (time.Time, bool) {
+	v, ok := inboxes.Load(msg.Reply)
+	if !ok {
+		return time.Time{}, false
+	}
+	d := v.(*inboxReply).deadline
+	return d, !d.IsZero()
+}
+
+// captured : stores the reply to a request not received through nats,
+// it returns false if the message was received through nats
+func captured(msg *nats.Msg, body []byte) bool {
+	if !strings.HasPrefix(msg.Reply, localInbox) {
+		return false
+	}
+
+	if v, ok := inboxes.Load(msg.Reply); ok {
+		inboxes.Delete(msg.Reply)
+		res := v.(*inboxReply)
+		res.body = body
+		close(res.done)
+	}
+
+	return true
+}
+
+// observe : stores the error of a request not received through nats,
+// and the outcome of the requests tracked by the metrics
+func observe(msg *nats.Msg, e *Error) {
+	if e == nil {
+		return
+	}
+	record(msg, e)
+	if v, ok := inboxes.Load(msg.Reply); ok {
+		v.(*inboxReply).err = e
+	}
+}
@@ -5,27 +5,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"os"
 	"time"
 
-	aes "github.com/ernestio/crypto/aes"
+	"github.com/jinzhu/gorm"
 	"github.com/nats-io/go-nats"
 	"github.com/r3labs/natsdb"
 )
 
 // Entity : the database mapped entity
 type Entity struct {
-	ID          uint     `json:"id" gorm:"primary_key"`
-	IDs         []string `json:"ids,omitempty" sql:"-"`
-	Name        string   `json:"name" gorm:"unique_index"`
-	Names       []string `json:"names,omitempty" sql:"-"`
-	Type        string   `json:"type"`
-	Credentials Map      `json:"credentials" gorm:"type: jsonb not null default '{}'::jsonb"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	DeletedAt   *time.Time `json:"-" sql:"index"`
+	ID             uint     `json:"id" gorm:"primary_key"`
+	IDs            []string `json:"ids,omitempty" sql:"-"`
+	Name           string   `json:"name" sql:"index"`
+	Names          []string `json:"names,omitempty" sql:"-"`
+	Type           string   `json:"type"`
+	OwnerID        uint     `json:"owner_id" sql:"index"`
+	GroupID        uint     `json:"group_id" sql:"index"`
+	Credentials    Map      `json:"credentials" gorm:"type: jsonb not null default '{}'::jsonb"`
+	Encrypted      []string `json:"encrypted_fields,omitempty" sql:"-"`
+	Fields         []string `json:"fields,omitempty" sql:"-"`
+	Redact         bool     `json:"redact,omitempty" sql:"-"`
+	Version        uint     `json:"version" gorm:"not null;default:1"`
+	Expected       uint     `json:"expected_version,omitempty" sql:"-"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" sql:"index"`
+	IncludeDeleted bool       `json:"include_deleted,omitempty" sql:"-"`
+	Limit          int        `json:"limit,omitempty" sql:"-"`
+	Offset         int        `json:"offset,omitempty" sql:"-"`
+	After          string     `json:"after,omitempty" sql:"-"`
+	OrderBy        string     `json:"order_by,omitempty" sql:"-"`
+	Types          []string   `json:"types,omitempty" sql:"-"`
+	NamePrefix     string     `json:"name_prefix,omitempty" sql:"-"`
+	NameContains   string     `json:"name_contains,omitempty" sql:"-"`
+	NameGlob       string     `json:"name_glob,omitempty" sql:"-"`
+	CreatedAfter   *time.Time `json:"created_after,omitempty" sql:"-"`
+	CreatedBefore  *time.Time `json:"created_before,omitempty" sql:"-"`
+	UpdatedAfter   *time.Time `json:"updated_after,omitempty" sql:"-"`
+	UpdatedBefore  *time.Time `json:"updated_before,omitempty" sql:"-"`
+	Actor          string     `json:"-" sql:"-"`
+	subject        string
+	caller         *Caller
+	ctx            context.Context
 }
 
 // TableName : set Entity's table name to be datacenters
@@ -36,30 +61,76 @@ func (Entity) TableName() string {
 // Find : based on the defined fields for the current entity
 // will perform a search on the database
 func (e *Entity) Find() []interface{} {
+	list, _ := e.find()
+	return list
+}
+
+func (e *Entity) find() ([]interface{}, error) {
 	entities := []Entity{}
-	if len(e.IDs) > 0 {
-		db.Where("id in (?)", e.IDs).Find(&entities)
-	} else if len(e.Names) > 0 {
-		db.Where("name in (?)", e.Names).Find(&entities)
-	} else if e.Name != "" {
-		db.Where("name = ?", e.Name).Find(&entities)
-	} else {
-		db.Find(&entities)
+
+	err := session(e.ctx, func(tx *gorm.DB) error {
+		q, err := e.filter(tx)
+		if err != nil {
+			return err
+		}
+
+		if q, err = e.order(q); err != nil {
+			return err
+		}
+
+		return dbError(q.Find(&entities).Error)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	return e.present(entities)
+}
+
+// present : redacts and projects the found entities as requested
+func (e *Entity) present(entities []Entity) ([]interface{}, error) {
+	// secrets are only decrypted if their credentials are returned
+	reveal := len(e.Fields) == 0 || contains(e.Fields, "credentials")
+
 	list := make([]interface{}, len(entities))
 	for i, s := range entities {
+		if e.Redact || len(e.Fields) > 0 {
+			s = s.redact(reveal)
+		}
 		list[i] = s
 	}
 
-	return list
+	if len(e.Fields) > 0 {
+		return project(list, e.Fields)
+	}
+
+	return list, nil
 }
 
 // MapInput : maps the input []byte on the current entity
 func (e *Entity) MapInput(body []byte) {
+	_ = e.mapInput(body)
+}
+
+func (e *Entity) mapInput(body []byte) error {
 	if err := json.Unmarshal(body, &e); err != nil {
 		log.Println("Invalid input " + err.Error())
+		return ErrInvalidInput
+	}
+
+	caller, err := callerOf(body)
+	if err != nil {
+		return err
+	}
+	if caller != nil {
+		e.caller = caller
+	}
+	if e.caller == nil && requireCaller {
+		return ErrUnauthorized
 	}
+	e.Actor = e.caller.actor()
+
+	return nil
 }
 
 // HasID : determines if the current entity has an id or not
@@ -72,28 +143,64 @@ func (e *Entity) HasID() bool {
 
 // LoadFromInput : Will load from a []byte input the database stored entity
 func (e *Entity) LoadFromInput(msg []byte) bool {
-	e.MapInput(msg)
-	var stored Entity
-	if e.ID != 0 {
-		db.First(&stored, e.ID)
-	} else if e.Name != "" {
-		db.Where("name = ?", e.Name).First(&stored)
+	return e.loadFromInput(msg) == nil
+}
+
+func (e *Entity) loadFromInput(msg []byte) error {
+	return e.loadFrom(false, msg)
+}
+
+// loadDeletedFromInput : loads the stored entity even if it was deleted
+func (e *Entity) loadDeletedFromInput(msg []byte) error {
+	return e.loadFrom(true, msg)
+}
+
+func (e *Entity) loadFrom(unscoped bool, msg []byte) error {
+	if err := e.mapInput(msg); err != nil {
+		return err
 	}
-	if &stored == nil {
-		return false
+
+	if e.ID == 0 && e.Name == "" {
+		return ErrNotFound
+	}
+
+	var stored Entity
+
+	err := session(e.ctx, func(tx *gorm.DB) error {
+		q := e.caller.scope(tx)
+		if unscoped {
+			q = q.Unscoped()
+		}
+
+		if e.ID != 0 {
+			return dbError(q.First(&stored, e.ID).Error)
+		}
+		if unscoped {
+			// deleted datacenters may share their name, the latest one is
+			// loaded unless it's in use
+			q = q.Order("deleted_at IS NOT NULL, deleted_at DESC")
+		}
+		return dbError(q.Where("name = ?", e.Name).First(&stored).Error)
+	})
+	if err != nil {
+		return err
 	}
 	if ok := stored.HasID(); !ok {
-		return false
+		return ErrNotFound
 	}
 
 	e.ID = stored.ID
 	e.Name = stored.Name
 	e.Type = stored.Type
+	e.OwnerID = stored.OwnerID
+	e.GroupID = stored.GroupID
 	e.Credentials = stored.Credentials
+	e.Version = stored.Version
 	e.CreatedAt = stored.CreatedAt
 	e.UpdatedAt = stored.UpdatedAt
+	e.DeletedAt = stored.DeletedAt
 
-	return true
+	return nil
 }
 
 // LoadFromInputOrFail : Will try to load from the input an existing entity,
@@ -109,16 +216,64 @@ func (e *Entity) LoadFromInputOrFail(msg *nats.Msg, h *natsdb.Handler) bool {
 	return ok
 }
 
-// Update : It will update the current entity with the input []byte
+// Update : It will update the current entity with the input []byte,
+// following json merge patch semantics for its credentials: given keys
+// are replaced, null keys are removed and omitted keys are kept
 func (e *Entity) Update(body []byte) error {
 	e.Credentials = make(Map)
 
-	e.MapInput(body)
+	if err := e.mapInput(body); err != nil {
+		return err
+	}
+
 	stored := Entity{}
-	db.First(&stored, e.ID)
+	err := session(e.ctx, func(tx *gorm.DB) error {
+		return dbError(tx.First(&stored, e.ID).Error)
+	})
+	if err != nil {
+		return err
+	}
+	if e.Expected != 0 && e.Expected != stored.Version {
+		return ErrVersionConflict
+	}
+	if stored.Credentials == nil {
+		stored.Credentials = make(Map)
+	}
+
+	before := stored
+	before.Credentials = make(Map, len(stored.Credentials))
+	for k, v := range stored.Credentials {
+		before.Credentials[k] = v
+	}
+
 	stored.Name = e.Name
+	if e.caller.isAdmin() {
+		stored.OwnerID = e.OwnerID
+		stored.GroupID = e.GroupID
+	}
+	if e.Type != "" && e.Type != stored.Type {
+		rc, err := reclassifyCredentials(stored.Type, e.Type, stored.Credentials)
+		if err != nil {
+			return err
+		}
+		stored.Type = e.Type
+		stored.Credentials = rc
+	}
 
-	ec, err := encryptCredentials(e.Credentials)
+	changed := make(Map)
+	for k, v := range e.Credentials {
+		if v == nil {
+			delete(stored.Credentials, k)
+			continue
+		}
+		changed[k] = v
+	}
+
+	if err := validateCredentials(stored.Type, changed, stored.Credentials); err != nil {
+		return err
+	}
+
+	ec, err := encryptCredentials(stored.Type, changed)
 	if err != nil {
 		return err
 	}
@@ -127,49 +282,227 @@ func (e *Entity) Update(body []byte) error {
 		stored.Credentials[k] = v
 	}
 
-	db.Save(&stored)
-	e = &stored
+	masked := stored.redacted()
+
+	err = transaction(e.ctx, func(tx *gorm.DB) error {
+		// when an expected version is given the row is only updated if it
+		// hasn't changed since it was read
+		q := tx.Model(&stored)
+		version := gorm.Expr("version + 1")
+		if e.Expected != 0 {
+			q = q.Where("version = ?", e.Expected)
+			version = gorm.Expr("?", e.Expected+1)
+		}
+
+		q = q.Updates(map[string]interface{}{
+			"name":        stored.Name,
+			"type":        stored.Type,
+			"owner_id":    stored.OwnerID,
+			"group_id":    stored.GroupID,
+			"credentials": stored.Credentials,
+			"version":     version,
+		})
+		if q.Error != nil {
+			return dbError(q.Error)
+		}
+		if q.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+
+		if err := tx.First(&stored, stored.ID).Error; err != nil {
+			return dbError(err)
+		}
+
+		return e.record(tx, "update", &before, &stored, masked)
+	})
+	if err != nil {
+		return err
+	}
+	*e = stored
 
 	return nil
 }
 
-// Delete : Will delete from database the current Entity
+// Delete : Will soft delete from database the current Entity, it can be
+// restored until it's purged
 func (e *Entity) Delete() error {
-	db.Unscoped().Delete(&e)
+	masked := e.redacted()
+	return transaction(e.ctx, func(tx *gorm.DB) error {
+		return e.delete(tx, "delete", masked)
+	})
+}
 
-	return nil
+// Purge : Will permanently delete from database the current Entity
+func (e *Entity) Purge() error {
+	masked := e.redacted()
+	return transaction(e.ctx, func(tx *gorm.DB) error {
+		return e.delete(tx.Unscoped(), "purge", masked)
+	})
 }
 
-func crypt(s string) (string, error) {
-	crypto := aes.New()
-	key := os.Getenv("ERNEST_CRYPTO_KEY")
-	if s != "" {
-		encrypted, err := crypto.Encrypt(s, key)
-		if err != nil {
-			return "", err
-		}
-		s = encrypted
+func (e *Entity) delete(tx *gorm.DB, op string, masked Entity) error {
+	q := tx
+	if e.Expected != 0 {
+		q = q.Where("version = ?", e.Expected)
 	}
 
-	return s, nil
+	q = q.Delete(&e)
+	if q.Error != nil {
+		return dbError(q.Error)
+	}
+	if e.Expected != 0 && q.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+
+	return e.record(tx, op, e, nil, masked)
+}
+
+// Restore : Will restore the current soft deleted Entity
+func (e *Entity) Restore() error {
+	if e.DeletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	before := *e
+	masked := e.redacted()
+
+	return transaction(e.ctx, func(tx *gorm.DB) error {
+		q := tx.Unscoped().Model(e).Where("deleted_at IS NOT NULL")
+		if e.Expected != 0 {
+			q = q.Where("version = ?", e.Expected)
+		}
+
+		q = q.UpdateColumns(map[string]interface{}{
+			"deleted_at": nil,
+			"version":    gorm.Expr("version + 1"),
+		})
+		if q.Error != nil {
+			return dbError(q.Error)
+		}
+		if q.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+
+		if err := tx.First(e, e.ID).Error; err != nil {
+			return dbError(err)
+		}
+
+		return e.record(tx, "restore", &before, e, masked)
+	})
 }
 
 // Save : Persists current entity on database
 func (e *Entity) Save() error {
-	ec, err := encryptCredentials(e.Credentials)
+	if err := e.caller.assign(e); err != nil {
+		return err
+	}
+
+	if err := validateCredentials(e.Type, e.Credentials, nil); err != nil {
+		return err
+	}
+
+	ec, err := encryptCredentials(e.Type, e.Credentials)
 	if err != nil {
 		return err
 	}
 
 	e.Credentials = ec
-	db.Save(&e)
+	e.Version = 1
+	masked := e.redacted()
+
+	return transaction(e.ctx, func(tx *gorm.DB) error {
+		if err := tx.Save(&e).Error; err != nil {
+			return dbError(err)
+		}
+
+		return e.record(tx, "create", nil, e, masked)
+	})
+}
+
+// transaction : runs the given function on a database transaction,
+// rolling it back if it fails. Its statements are cancelled once the
+// context deadline is exceeded
+func transaction(ctx context.Context, f func(tx *gorm.DB) error) error {
+	defer observeDB("write", time.Now())
+
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return dbError(err)
+	}
+	notifyOutbox()
 
 	return nil
 }
 
-func encryptCredentials(c Map) (Map, error) {
+// session : runs the given function on the database, bounding its
+// statements to the context deadline when it has one
+func session(ctx context.Context, f func(tx *gorm.DB) error) error {
+	defer observeDB("read", time.Now())
+
+	if _, ok := deadline(ctx); !ok {
+		return f(db)
+	}
+
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return dbError(tx.Commit().Error)
+}
+
+// begin : begins a transaction whose statements are cancelled by
+// postgres once the context deadline is exceeded
+func begin(ctx context.Context) (*gorm.DB, error) {
+	d, ok := deadline(ctx)
+	if ok && time.Until(d) < time.Millisecond {
+		return nil, ErrTimeout
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, dbError(tx.Error)
+	}
+
+	if ok {
+		timeout := time.Until(d) / time.Millisecond
+		if timeout < 1 {
+			tx.Rollback()
+			return nil, ErrTimeout
+		}
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)).Error; err != nil {
+			tx.Rollback()
+			return nil, dbError(err)
+		}
+	}
+
+	return tx, nil
+}
+
+func deadline(ctx context.Context) (time.Time, bool) {
+	if ctx == nil {
+		return time.Time{}, false
+	}
+	return ctx.Deadline()
+}
+
+func encryptCredentials(t string, c Map) (Map, error) {
 	for k, v := range c {
-		if k == "region" || k == "vdc" || k == "username" || k == "vcloud_url" {
+		if !isEncrypted(t, k) {
 			continue
 		}
 
@@ -180,7 +513,8 @@ func encryptCredentials(c Map) (Map, error) {
 
 		x, err := crypt(xc)
 		if err != nil {
-			return c, err
+			log.Println("Could not encrypt credentials " + err.Error())
+			return c, ErrEncryption
 		}
 
 		c[k] = x
@@ -188,3 +522,55 @@ func encryptCredentials(c Map) (Map, error) {
 
 	return c, nil
 }
+
+func decryptCredentials(t string, c Map) (Map, error) {
+	dc := make(Map, len(c))
+	for k, v := range c {
+		dc[k] = v
+
+		xc, ok := v.(string)
+		if !ok || !isEncrypted(t, k) {
+			continue
+		}
+
+		x, err := decrypt(xc)
+		if err != nil {
+			log.Println("Could not decrypt credentials " + err.Error())
+			return c, ErrDecryption
+		}
+
+		dc[k] = x
+	}
+
+	return dc, nil
+}
+
+// reclassifyCredentials : decrypts or encrypts the stored credentials
+// whose classification differs between the old and new datacenter types
+func reclassifyCredentials(from, to string, c Map) (Map, error) {
+	rc := make(Map, len(c))
+	for k, v := range c {
+		rc[k] = v
+
+		xc, ok := v.(string)
+		if !ok || isEncrypted(from, k) == isEncrypted(to, k) {
+			continue
+		}
+
+		var x string
+		var err error
+		if isEncrypted(from, k) {
+			x, err = decrypt(xc)
+		} else {
+			x, err = crypt(xc)
+		}
+		if err != nil {
+			log.Println("Could not reclassify credentials " + err.Error())
+			return c, ErrEncryption
+		}
+
+		rc[k] = x
+	}
+
+	return rc, nil
+}
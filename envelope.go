@@ -0,0 +1,148 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nats-io/go-nats"
+)
+
+// APIVersion : current version of the request and response envelope,
+// requests without an api_version are handled as v1 legacy requests
+const APIVersion = "2"
+
+// Request : versioned request envelope, data holds the v1 request body
+type Request struct {
+	APIVersion string          `json:"api_version"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Response : versioned response envelope, it holds either the data or
+// the error of the reply
+type Response struct {
+	APIVersion string          `json:"api_version"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Error      *Error          `json:"error,omitempty"`
+}
+
+// ErrAPIVersion : the request envelope has an unsupported api_version
+var ErrAPIVersion = &Error{Code: "400", Message: "Unsupported api version", Field: "api_version"}
+
+// v2Fields : fields renamed on v2 responses, v1 responses keep go's
+// default names for the entity timestamps
+var v2Fields = map[string]string{
+	"CreatedAt": "created_at",
+	"UpdatedAt": "updated_at",
+}
+
+// envelopes : requests being handled with a versioned envelope, replies
+// to them are wrapped on a response envelope
+var envelopes sync.Map
+
+// envelope : unwraps versioned requests before running the handler, v1
+// legacy requests are passed through untouched
+func envelope(h handlerFunc) handlerFunc {
+	return func(ctx context.Context, msg *nats.Msg) {
+		var probe struct {
+			APIVersion string `json:"api_version"`
+		}
+		if json.Unmarshal(msg.Data, &probe) != nil || probe.APIVersion == "" {
+			h(ctx, msg)
+			return
+		}
+
+		var r Request
+		if err := json.Unmarshal(msg.Data, &r); err != nil {
+			r.APIVersion = APIVersion
+			wrapped(msg, &r, nil, ErrInvalidInput)
+			return
+		}
+
+		if r.APIVersion != APIVersion {
+			wrapped(msg, &Request{APIVersion: APIVersion, RequestID: r.RequestID}, nil, ErrAPIVersion)
+			return
+		}
+
+		m := &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Data: []byte(r.Data), Sub: msg.Sub}
+		envelopes.Store(m, &r)
+		defer envelopes.Delete(m)
+		follow(msg, m)
+		defer untrack(m)
+
+		h(ctx, m)
+	}
+}
+
+// send : publishes the reply body, wrapping it on a response envelope
+// when the request was received on one
+func send(msg *nats.Msg, body []byte, e *Error) {
+	if v, ok := envelopes.Load(msg); ok {
+		wrapped(msg, v.(*Request), body, e)
+		return
+	}
+
+	if e != nil {
+		observe(msg, e)
+		body = e.Encoded()
+	}
+	reply(msg, body)
+}
+
+func wrapped(msg *nats.Msg, r *Request, body []byte, e *Error) {
+	observe(msg, e)
+
+	res := Response{APIVersion: r.APIVersion, RequestID: r.RequestID, Error: e}
+	if e == nil {
+		res.Data = rename(body)
+	}
+
+	out, err := json.Marshal(res)
+	if err != nil {
+		out, _ = json.Marshal(Response{APIVersion: r.APIVersion, RequestID: r.RequestID, Error: ErrUnexpected})
+	}
+
+	reply(msg, out)
+}
+
+// rename : renames the v1 field names of the body to their v2 names
+func rename(body []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+
+	out, err := json.Marshal(renameFields(v))
+	if err != nil {
+		return body
+	}
+
+	return out
+}
+
+func renameFields(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			if name, ok := v2Fields[k]; ok {
+				k = name
+			}
+			m[k] = renameFields(x)
+		}
+		return m
+	case []interface{}:
+		for i, x := range t {
+			t[i] = renameFields(x)
+		}
+		return t
+	}
+
+	return v
+}
@@ -0,0 +1,105 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEnvelope(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_envelope")
+	setupPg("test_envelope")
+	startHandler()
+
+	request := func(subject, body string) Response {
+		res := Response{}
+		msg, err := n.Request(subject, []byte(body), time.Second)
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(msg.Data, &res), ShouldBeNil)
+		return res
+	}
+
+	Convey("Scenario: renaming v1 fields", t, func() {
+		body := rename([]byte(`[{"id":1,"CreatedAt":"2017-08-01T10:00:00Z","credentials":{"UpdatedAt":"x"}}]`))
+		So(string(body), ShouldEqual, `[{"created_at":"2017-08-01T10:00:00Z","credentials":{"updated_at":"x"},"id":1}]`)
+	})
+
+	Convey("Scenario: requesting with a versioned envelope", t, func() {
+		setupTestSuite()
+
+		Convey("Given a valid request", func() {
+			res := request("datacenter.set", `{"api_version":"2","request_id":"abc","data":{"name":"enveloped","type":"fake"}}`)
+			So(res.APIVersion, ShouldEqual, "2")
+			So(res.RequestID, ShouldEqual, "abc")
+			So(res.Error, ShouldBeNil)
+
+			var data map[string]interface{}
+			So(json.Unmarshal(res.Data, &data), ShouldBeNil)
+			So(data["name"], ShouldEqual, "enveloped")
+			So(data["created_at"], ShouldNotBeNil)
+			So(data["CreatedAt"], ShouldBeNil)
+		})
+
+		Convey("Given a request failing", func() {
+			res := request("datacenter.get", `{"api_version":"2","request_id":"def","data":{"name":"unknown"}}`)
+			So(res.RequestID, ShouldEqual, "def")
+			So(res.Data, ShouldBeNil)
+			So(res.Error, ShouldNotBeNil)
+			So(res.Error.Code, ShouldEqual, "404")
+		})
+
+		Convey("Given an unsupported api version", func() {
+			res := request("datacenter.get", `{"api_version":"3","request_id":"ghi","data":{"name":"unknown"}}`)
+			So(res.APIVersion, ShouldEqual, "2")
+			So(res.RequestID, ShouldEqual, "ghi")
+			So(res.Error.Field, ShouldEqual, "api_version")
+		})
+
+		Convey("Given a legacy request", func() {
+			msg, err := n.Request("datacenter.get", []byte(`{"name":"unknown"}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrNotFound.Encoded()))
+		})
+	})
+
+	Convey("Scenario: getting the json schemas", t, func() {
+		msg, err := n.Request("datacenter.schema", []byte(``), time.Second)
+		So(err, ShouldBeNil)
+
+		s := Schemas{}
+		So(json.Unmarshal(msg.Data, &s), ShouldBeNil)
+		So(s.APIVersion, ShouldEqual, APIVersion)
+
+		var req, res map[string]interface{}
+		So(json.Unmarshal(s.Request, &req), ShouldBeNil)
+		So(json.Unmarshal(s.Response, &res), ShouldBeNil)
+		So(req["title"], ShouldEqual, "Datacenter store request")
+		So(res["title"], ShouldEqual, "Datacenter store response")
+
+		Convey("Then the request data lists the find fields", func() {
+			data := req["properties"].(map[string]interface{})["data"].(map[string]interface{})
+			props := data["properties"].(map[string]interface{})
+			for _, f := range []string{"ids", "names", "types", "limit", "offset", "after", "order_by", "fields", "redact", "include_deleted", "name_prefix", "name_contains", "name_glob", "created_after", "created_before", "updated_after", "updated_before"} {
+				So(props, ShouldContainKey, f)
+			}
+		})
+
+		Convey("Then any of the response data shapes is valid", func() {
+			data := res["properties"].(map[string]interface{})["data"].(map[string]interface{})
+			So(data, ShouldContainKey, "anyOf")
+			So(data, ShouldNotContainKey, "oneOf")
+		})
+	})
+}
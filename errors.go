@@ -0,0 +1,124 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Error : structured error replied on every datacenter subject
+type Error struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Field     string       `json:"field,omitempty"`
+	Retryable bool         `json:"retryable"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// Error : returns the error string
+func (e *Error) Error() string {
+	if e.Field != "" {
+		return e.Message + ": " + e.Field
+	}
+	return e.Message
+}
+
+// Encoded : returns the json encoded error
+func (e *Error) Encoded() []byte {
+	body, _ := json.Marshal(e)
+	return body
+}
+
+// Status : returns the http status code of the error
+func (e *Error) Status() int {
+	status, err := strconv.Atoi(e.Code)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+var (
+	// ErrInvalidInput : the request body is not a valid datacenter
+	ErrInvalidInput = &Error{Code: "400", Message: "Invalid input"}
+	// ErrUnauthorized : the request has no caller
+	ErrUnauthorized = &Error{Code: "401", Message: "Caller is required", Field: "caller"}
+	// ErrUnauthenticated : the service of the caller could not be authenticated
+	ErrUnauthenticated = &Error{Code: "401", Message: "Caller could not be authenticated", Field: "caller.token"}
+	// ErrForbidden : the caller is not allowed to perform the request
+	ErrForbidden = &Error{Code: "403", Message: "Forbidden"}
+	// ErrUnknownType : the datacenter type has no credential schema
+	ErrUnknownType = &Error{Code: "422", Message: "Unknown datacenter type", Field: "type"}
+	// ErrNotFound : the requested datacenter does not exist
+	ErrNotFound = &Error{Code: "404", Message: "Not found"}
+	// ErrConflict : a datacenter with the same name already exists
+	ErrConflict = &Error{Code: "409", Message: "Datacenter already exists", Field: "name"}
+	// ErrNotDeleted : the datacenter to restore is not deleted
+	ErrNotDeleted = &Error{Code: "409", Message: "Datacenter is not deleted"}
+	// ErrVersionConflict : the datacenter was modified after the expected version
+	ErrVersionConflict = &Error{Code: "409", Message: "Datacenter was modified", Field: "expected_version"}
+	// ErrEncryption : credentials could not be encrypted
+	ErrEncryption = &Error{Code: "500", Message: "Could not encrypt credentials"}
+	// ErrDecryption : credentials could not be decrypted
+	ErrDecryption = &Error{Code: "500", Message: "Could not decrypt credentials"}
+	// ErrUnexpected : any other error
+	ErrUnexpected = &Error{Code: "500", Message: "Unexpected error"}
+	// ErrDatabase : the database could not process the request
+	ErrDatabase = &Error{Code: "503", Message: "Database error", Retryable: true}
+)
+
+// uniqueViolation : postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// queryCanceled : postgres error code for statements cancelled after
+// their statement_timeout
+const queryCanceled = "57014"
+
+// toError : maps any error returned while processing a request to its
+// structured error reply
+func toError(err error) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case *ValidationError:
+		v := &Error{Code: "422", Message: "Invalid credentials", Field: "credentials", Fields: e.Fields}
+		if len(e.Fields) > 0 {
+			v.Field = e.Fields[0].Field
+		}
+		return v
+	}
+
+	return ErrUnexpected
+}
+
+// dbError : maps a database error to its structured error
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		return ErrNotFound
+	}
+
+	if perr, ok := err.(*pq.Error); ok {
+		switch perr.Code {
+		case uniqueViolation:
+			return ErrConflict
+		case queryCanceled:
+			return ErrTimeout
+		}
+	}
+
+	log.Println("Database error " + err.Error())
+
+	return ErrDatabase
+}
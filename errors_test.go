@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrors(t *testing.T) {
+	Convey("Scenario: mapping errors to structured replies", t, func() {
+		Convey("Given a validation error", func() {
+			err := toError(&ValidationError{Fields: []FieldError{{Field: "credentials.region", Message: "is required"}}})
+			So(err.Code, ShouldEqual, "422")
+			So(err.Field, ShouldEqual, "credentials.region")
+			So(len(err.Fields), ShouldEqual, 1)
+		})
+
+		Convey("Given a validation error without fields", func() {
+			err := toError(&ValidationError{})
+			So(err.Code, ShouldEqual, "422")
+			So(err.Field, ShouldEqual, "credentials")
+		})
+
+		Convey("Given an unknown error", func() {
+			So(toError(errors.New("boom")), ShouldEqual, ErrUnexpected)
+		})
+
+		Convey("Given a unique violation from the database", func() {
+			So(dbError(&pq.Error{Code: "23505"}), ShouldEqual, ErrConflict)
+		})
+
+		Convey("Given a record not found error from the database", func() {
+			So(dbError(gorm.ErrRecordNotFound), ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Given any other database error", func() {
+			err := toError(dbError(errors.New("connection refused")))
+			So(err.Code, ShouldEqual, "503")
+			So(err.Retryable, ShouldBeTrue)
+		})
+	})
+
+	Convey("Scenario: encoding an error", t, func() {
+		var output map[string]interface{}
+		err := json.Unmarshal(ErrConflict.Encoded(), &output)
+		So(err, ShouldBeNil)
+		So(output["code"], ShouldEqual, "409")
+		So(output["field"], ShouldEqual, "name")
+		So(output["retryable"], ShouldEqual, false)
+	})
+}
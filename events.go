@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// eventSubjects : subject each operation is published on
+var eventSubjects = map[string]string{
+	"create":  "datacenter.created",
+	"update":  "datacenter.updated",
+	"restore": "datacenter.updated",
+	"delete":  "datacenter.deleted",
+	"purge":   "datacenter.deleted",
+}
+
+// outboxBatchSize : number of events published per batch
+const outboxBatchSize = 100
+
+// outboxLock : advisory lock held while publishing a batch, so a single
+// replica relays the outbox at a time
+const outboxLock = 7405212
+
+// Event : change event stored on the outbox on the same transaction as
+// the change, and published once it's committed
+type Event struct {
+	ID           uint      `json:"id" gorm:"primary_key"`
+	Subject      string    `json:"-"`
+	Operation    string    `json:"operation"`
+	DatacenterID uint      `json:"datacenter_id"`
+	Version      uint      `json:"version"`
+	Datacenter   Map       `json:"datacenter" gorm:"type: jsonb not null default '{}'::jsonb"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName : set Event's table name to be datacenter_outbox
+func (Event) TableName() string {
+	return "datacenter_outbox"
+}
+
+var outboxSignal = make(chan struct{}, 1)
+
+// record : records a mutation of the entity on the audit trail and the
+// events outbox. The credentials of the event are taken from masked, the
+// redacted datacenter, so secrets are decrypted before the transaction.
+// Events are not stored while nats is disabled, as nothing relays them
+func (e *Entity) record(tx *gorm.DB, op string, before, after *Entity, masked Entity) error {
+	if err := e.audit(tx, op, before, after); err != nil {
+		return err
+	}
+	if !natsEnabled() {
+		return nil
+	}
+
+	current := after
+	if current == nil {
+		current = before
+	}
+
+	payload := *current
+	payload.Credentials = masked.Credentials
+	payload.Encrypted = masked.Encrypted
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var m Map
+	if err := json.Unmarshal(body, &m); err != nil {
+		return err
+	}
+
+	ev := Event{
+		Subject:      eventSubjects[op],
+		Operation:    op,
+		DatacenterID: current.ID,
+		Version:      current.Version,
+		Datacenter:   m,
+	}
+
+	return dbError(tx.Create(&ev).Error)
+}
+
+// notifyOutbox : wakes up the outbox relay
+func notifyOutbox() {
+	select {
+	case outboxSignal <- struct{}{}:
+	default:
+	}
+}
+
+// publishOutbox : publishes every pending event, in order for each
+// datacenter, removing them from the outbox once published. Only the
+// replica holding the outbox lock publishes, the others leave the events
+// to it
+func publishOutbox() error {
+	for {
+		published, err := publishBatch()
+		if err != nil || published < outboxBatchSize {
+			return err
+		}
+	}
+}
+
+func publishBatch() (int, error) {
+	var events []Event
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return 0, dbError(tx.Error)
+	}
+
+	var locked bool
+	if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", outboxLock).Row().Scan(&locked); err != nil || !locked {
+		tx.Rollback()
+		return 0, dbError(err)
+	}
+
+	if err := tx.Order("id").Limit(outboxBatchSize).Find(&events).Error; err != nil {
+		tx.Rollback()
+		return 0, dbError(err)
+	}
+
+	for i, ev := range events {
+		body, err := json.Marshal(ev)
+		if err == nil {
+			err = n.Publish(ev.Subject, body)
+		}
+		if err == nil {
+			err = dbError(tx.Delete(&ev).Error)
+		}
+		if err != nil {
+			// events published so far are removed, the rest are retried
+			if cerr := tx.Commit().Error; cerr != nil {
+				return 0, dbError(cerr)
+			}
+			return i, err
+		}
+	}
+
+	return len(events), dbError(tx.Commit().Error)
+}
+
+// startOutbox : publishes pending events after every change, and on the
+// given interval to recover events left by a failure
+func startOutbox(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-outboxSignal:
+			case <-ticker.C:
+			case <-stopping:
+				ticker.Stop()
+				return
+			}
+
+			if err := publishOutbox(); err != nil {
+				log.Println("could not publish events: " + err.Error())
+			}
+		}
+	}()
+}
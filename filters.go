@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// filter : returns the query matching every search field of the entity
+func (e *Entity) filter(q *gorm.DB) (*gorm.DB, error) {
+	q = e.caller.scope(q.Model(&Entity{}))
+	if e.IncludeDeleted {
+		q = q.Unscoped()
+	}
+
+	if len(e.IDs) > 0 {
+		q = q.Where("id in (?)", e.IDs)
+	} else if len(e.Names) > 0 {
+		q = q.Where("name in (?)", e.Names)
+	} else if e.Name != "" {
+		q = q.Where("name = ?", e.Name)
+	}
+
+	if e.NamePrefix != "" {
+		q = q.Where("name LIKE ?", escapeLike(e.NamePrefix)+"%")
+	}
+	if e.NameContains != "" {
+		q = q.Where("name LIKE ?", "%"+escapeLike(e.NameContains)+"%")
+	}
+	if e.NameGlob != "" {
+		q = q.Where("name LIKE ?", globToLike(e.NameGlob))
+	}
+
+	types := e.filteredTypes()
+	if len(types) > 0 {
+		q = q.Where("type in (?)", types)
+	}
+
+	if e.CreatedAfter != nil {
+		q = q.Where("created_at >= ?", e.CreatedAfter)
+	}
+	if e.CreatedBefore != nil {
+		q = q.Where("created_at <= ?", e.CreatedBefore)
+	}
+	if e.UpdatedAfter != nil {
+		q = q.Where("updated_at >= ?", e.UpdatedAfter)
+	}
+	if e.UpdatedBefore != nil {
+		q = q.Where("updated_at <= ?", e.UpdatedBefore)
+	}
+
+	keys := make([]string, 0, len(e.Credentials))
+	for k := range e.Credentials {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, ok := e.Credentials[k].(string)
+		if !ok || !filterable(k, types) {
+			return nil, &Error{Code: "400", Message: "Invalid filter", Field: "credentials." + k}
+		}
+		q = q.Where("credentials->>? = ?", k, v)
+	}
+
+	return q, nil
+}
+
+// filteredTypes : returns the types to filter by, either a single type
+// or a list of them
+func (e *Entity) filteredTypes() []string {
+	types := append([]string{}, e.Types...)
+	if e.Type != "" {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// filterable : determines if datacenters can be filtered by a credential
+// key. Only keys stored in plaintext can be, for every given type or for
+// at least one of the known types if none is given
+func filterable(key string, types []string) bool {
+	if len(types) > 0 {
+		for _, t := range types {
+			if isEncrypted(t, key) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if contains(defaultPlaintext, key) {
+		return true
+	}
+	for _, t := range knownTypes() {
+		if !isEncrypted(t, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike : escapes the LIKE wildcards of a literal string
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// globToLike : converts a glob pattern using * and ? to a LIKE pattern
+func globToLike(s string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(s))
+}
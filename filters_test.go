@@ -0,0 +1,32 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilters(t *testing.T) {
+	Convey("Scenario: converting name patterns", t, func() {
+		So(escapeLike(`50%_off\`), ShouldEqual, `50\%\_off\\`)
+		So(globToLike("prod-*-eu?"), ShouldEqual, "prod-%-eu_")
+		So(globToLike("a_b*"), ShouldEqual, `a\_b%`)
+	})
+
+	Convey("Scenario: validating credential filters", t, func() {
+		So(filterable("region", nil), ShouldBeTrue)
+		So(filterable("region", []string{"aws"}), ShouldBeTrue)
+		So(filterable("region", []string{"aws", "azure"}), ShouldBeTrue)
+		So(filterable("subscription_id", nil), ShouldBeTrue)
+		So(filterable("subscription_id", []string{"azure"}), ShouldBeTrue)
+		So(filterable("subscription_id", []string{"aws", "azure"}), ShouldBeFalse)
+		So(filterable("external_network", []string{"vcloud"}), ShouldBeTrue)
+		So(filterable("vdc", []string{"vcloud"}), ShouldBeTrue)
+		So(filterable("secret_access_key", nil), ShouldBeFalse)
+		So(filterable("password", []string{"vcloud"}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,364 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ernestio/datacenter-store/pb"
+	"github.com/nats-io/go-nats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodes : grpc status code of each error code
+var grpcCodes = map[string]codes.Code{
+	"400": codes.InvalidArgument,
+	"401": codes.Unauthenticated,
+	"403": codes.PermissionDenied,
+	"404": codes.NotFound,
+	"409": codes.Aborted,
+	"422": codes.InvalidArgument,
+	"429": codes.ResourceExhausted,
+	"503": codes.Unavailable,
+	"504": codes.DeadlineExceeded,
+}
+
+// ErrEventsUnavailable : changes can't be watched without nats
+var ErrEventsUnavailable = &Error{Code: "503", Message: "Events are not available"}
+
+// grpcSrv : server of the grpc api, if it's enabled
+var grpcSrv *grpc.Server
+
+func startGRPC() {
+	addr := os.Getenv("ERNEST_GRPC_ADDR")
+	if addr == "" {
+		return
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("could not listen on " + addr + ": " + err.Error())
+	}
+
+	grpcSrv = grpcAPI()
+
+	go func() {
+		log.Println("Serving grpc api on " + addr)
+		if err := grpcSrv.Serve(l); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// grpcAPI : serves the datacenter subjects as the DatacenterStore service
+func grpcAPI() *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterDatacenterStoreServer(s, &grpcServer{})
+	return s
+}
+
+// grpcServer : implements the DatacenterStore service through the same
+// handlers serving the datacenter subjects
+type grpcServer struct{}
+
+// Get : returns the datacenter matching the given id or name
+func (s *grpcServer) Get(ctx context.Context, in *pb.GetRequest) (*pb.Datacenter, error) {
+	input := grpcInput(in.Caller)
+	reference(input, in.Id, in.Name)
+
+	return datacenterCall(ctx, "datacenter.get", input)
+}
+
+// Set : creates or updates a datacenter
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.Datacenter, error) {
+	input := grpcInput(in.Caller)
+
+	if d := in.Datacenter; d != nil {
+		reference(input, d.Id, d.Name)
+		setField(input, "type", d.Type)
+		setField(input, "owner_id", d.OwnerId)
+		setField(input, "group_id", d.GroupId)
+	}
+
+	// removed keys are given as null credentials, which datacenter.set
+	// removes from the stored ones
+	credentials := map[string]interface{}{}
+	for k, v := range in.GetDatacenter().GetCredentials() {
+		credentials[k] = v
+	}
+	for _, k := range in.RemovedCredentials {
+		credentials[k] = nil
+	}
+	if len(credentials) > 0 {
+		input["credentials"] = credentials
+	}
+	setField(input, "expected_version", in.ExpectedVersion)
+
+	return datacenterCall(ctx, "datacenter.set", input)
+}
+
+// Delete : deletes the datacenter matching the given id or name
+func (s *grpcServer) Delete(ctx context.Context, in *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	input := grpcInput(in.Caller)
+	reference(input, in.Id, in.Name)
+	setField(input, "expected_version", in.ExpectedVersion)
+
+	body, e := call(ctx, "datacenter.del", input)
+	if e != nil {
+		return nil, grpcError(e)
+	}
+
+	res := pb.DeleteResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, grpcError(ErrUnexpected)
+	}
+
+	return &res, nil
+}
+
+// Find : returns the datacenters matching the given filters
+func (s *grpcServer) Find(ctx context.Context, in *pb.FindRequest) (*pb.FindResponse, error) {
+	input := grpcInput(in.Caller)
+
+	if len(in.Ids) > 0 {
+		ids := make([]string, len(in.Ids))
+		for i, id := range in.Ids {
+			ids[i] = strconv.FormatUint(id, 10)
+		}
+		input["ids"] = ids
+	}
+	if len(in.Names) > 0 {
+		input["names"] = in.Names
+	}
+	if len(in.Types) > 0 {
+		input["types"] = in.Types
+	}
+	if len(in.Credentials) > 0 {
+		input["credentials"] = in.Credentials
+	}
+	setField(input, "name", in.Name)
+	setField(input, "type", in.Type)
+	setField(input, "name_prefix", in.NamePrefix)
+	setField(input, "name_contains", in.NameContains)
+	setField(input, "name_glob", in.NameGlob)
+	setField(input, "created_after", in.CreatedAfter)
+	setField(input, "created_before", in.CreatedBefore)
+	setField(input, "updated_after", in.UpdatedAfter)
+	setField(input, "updated_before", in.UpdatedBefore)
+	setField(input, "include_deleted", in.IncludeDeleted)
+	setField(input, "redact", in.Redact)
+	setField(input, "limit", in.Limit)
+	setField(input, "offset", in.Offset)
+	setField(input, "after", in.After)
+	setField(input, "order_by", in.OrderBy)
+
+	body, e := call(ctx, "datacenter.find", input)
+	if e != nil {
+		return nil, grpcError(e)
+	}
+
+	var page struct {
+		Items []Entity `json:"items"`
+		Total int      `json:"total"`
+		Next  string   `json:"next"`
+	}
+
+	var err error
+	if in.Limit > 0 || in.Offset > 0 || in.After != "" {
+		err = json.Unmarshal(body, &page)
+	} else {
+		err = json.Unmarshal(body, &page.Items)
+		page.Total = len(page.Items)
+	}
+	if err != nil {
+		return nil, grpcError(ErrUnexpected)
+	}
+
+	res := pb.FindResponse{Total: int32(page.Total), Next: page.Next}
+	for _, e := range page.Items {
+		res.Datacenters = append(res.Datacenters, toProto(e))
+	}
+
+	return &res, nil
+}
+
+// Watch : streams the change events published on nats, filtered by
+// operation, datacenter and the datacenters visible to the caller
+func (s *grpcServer) Watch(in *pb.WatchRequest, stream pb.DatacenterStore_WatchServer) error {
+	caller := toCaller(in.Caller)
+	if caller == nil && requireCaller {
+		return grpcError(ErrUnauthorized)
+	}
+	if caller != nil {
+		if err := caller.authenticate(); err != nil {
+			return grpcError(toError(err))
+		}
+	}
+	if err := permit(caller, "datacenter.watch"); err != nil {
+		return grpcError(toError(err))
+	}
+
+	if n == nil {
+		return grpcError(ErrEventsUnavailable)
+	}
+
+	ch := make(chan *nats.Msg, 64)
+	subscribed := map[string]bool{}
+	for _, subject := range eventSubjects {
+		if subscribed[subject] {
+			continue
+		}
+		subscribed[subject] = true
+
+		sub, err := n.ChanSubscribe(subject, ch)
+		if err != nil {
+			return grpcError(ErrEventsUnavailable)
+		}
+		defer func() { _ = sub.Unsubscribe() }()
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-stopping:
+			return nil
+		case msg := <-ch:
+			var ev Event
+			if err := json.Unmarshal(msg.Data, &ev); err != nil {
+				log.Println("Invalid event on " + msg.Subject)
+				continue
+			}
+
+			if len(in.Operations) > 0 && !contains(in.Operations, ev.Operation) {
+				continue
+			}
+			if in.DatacenterId != 0 && uint64(ev.DatacenterID) != in.DatacenterId {
+				continue
+			}
+
+			var dc Entity
+			body, _ := json.Marshal(ev.Datacenter)
+			if err := json.Unmarshal(body, &dc); err != nil {
+				continue
+			}
+			if !caller.sees(dc.OwnerID, dc.GroupID) {
+				continue
+			}
+
+			err := stream.Send(&pb.Event{
+				Operation:    ev.Operation,
+				DatacenterId: uint64(ev.DatacenterID),
+				Version:      uint64(ev.Version),
+				Datacenter:   toProto(dc),
+				CreatedAt:    ev.CreatedAt.Format(time.RFC3339Nano),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// datacenterCall : runs the handler of the given subject, replying with
+// a single datacenter
+func datacenterCall(ctx context.Context, subject string, input map[string]interface{}) (*pb.Datacenter, error) {
+	body, e := call(ctx, subject, input)
+	if e != nil {
+		return nil, grpcError(e)
+	}
+
+	var dc Entity
+	if err := json.Unmarshal(body, &dc); err != nil {
+		return nil, grpcError(ErrUnexpected)
+	}
+
+	return toProto(dc), nil
+}
+
+func grpcInput(c *pb.Caller) map[string]interface{} {
+	input := map[string]interface{}{}
+	if caller := toCaller(c); caller != nil {
+		input["caller"] = caller
+	}
+	return input
+}
+
+// reference : references the datacenter by its id, or by its name if
+// the id is not given
+func reference(input map[string]interface{}, id uint64, name string) {
+	setField(input, "id", id)
+	setField(input, "name", name)
+}
+
+// set : sets the field on the input unless it has its zero value
+func setField(input map[string]interface{}, field string, v interface{}) {
+	switch v {
+	case "", uint64(0), int32(0), false:
+		return
+	}
+	input[field] = v
+}
+
+func grpcError(e *Error) error {
+	code, ok := grpcCodes[e.Code]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, e.Error())
+}
+
+func toCaller(c *pb.Caller) *Caller {
+	if c == nil {
+		return nil
+	}
+
+	caller := Caller{UserID: uint(c.UserId), Roles: c.Roles, Service: c.Service, Token: c.Token}
+	for _, id := range c.GroupIds {
+		caller.GroupIDs = append(caller.GroupIDs, uint(id))
+	}
+
+	return &caller
+}
+
+func toProto(e Entity) *pb.Datacenter {
+	dc := pb.Datacenter{
+		Id:              uint64(e.ID),
+		Name:            e.Name,
+		Type:            e.Type,
+		OwnerId:         uint64(e.OwnerID),
+		GroupId:         uint64(e.GroupID),
+		EncryptedFields: e.Encrypted,
+		Version:         uint64(e.Version),
+		CreatedAt:       e.CreatedAt.Format(time.RFC3339Nano),
+		UpdatedAt:       e.UpdatedAt.Format(time.RFC3339Nano),
+	}
+
+	if e.DeletedAt != nil {
+		dc.DeletedAt = e.DeletedAt.Format(time.RFC3339Nano)
+	}
+
+	if len(e.Credentials) > 0 {
+		dc.Credentials = make(map[string]string, len(e.Credentials))
+		for k, v := range e.Credentials {
+			if s, ok := v.(string); ok {
+				dc.Credentials[k] = s
+			} else if v != nil {
+				dc.Credentials[k] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	return &dc
+}
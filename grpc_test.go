@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ernestio/datacenter-store/pb"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_grpc")
+	setupPg("test_grpc")
+	startOutbox(time.Millisecond * 100)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpcAPI()
+	go func() { _ = s.Serve(l) }()
+	defer s.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := pb.NewDatacenterStoreClient(conn)
+	ctx := context.Background()
+
+	Convey("Scenario: converting datacenters", t, func() {
+		dc := toProto(Entity{ID: 1, Name: "test", Credentials: Map{"username": "john", "port": float64(22), "empty": nil}})
+		So(dc.Id, ShouldEqual, 1)
+		So(dc.Credentials, ShouldResemble, map[string]string{"username": "john", "port": "22"})
+		So(dc.DeletedAt, ShouldEqual, "")
+	})
+
+	Convey("Scenario: mapping errors to grpc status codes", t, func() {
+		So(status.Code(grpcError(ErrNotFound)), ShouldEqual, codes.NotFound)
+		So(status.Code(grpcError(ErrVersionConflict)), ShouldEqual, codes.Aborted)
+		So(status.Code(grpcError(ErrDatabase)), ShouldEqual, codes.Unavailable)
+		So(status.Code(grpcError(ErrUnexpected)), ShouldEqual, codes.Internal)
+	})
+
+	Convey("Scenario: serving datacenters through the grpc api", t, func() {
+		setupTestSuite()
+
+		created, err := client.Set(ctx, &pb.SetRequest{Datacenter: &pb.Datacenter{Name: "grpc-dc", Type: "fake", Credentials: map[string]string{"username": "john"}}})
+		So(err, ShouldBeNil)
+		So(created.Id, ShouldNotEqual, 0)
+		So(created.Version, ShouldEqual, 1)
+
+		Convey("Given a datacenter is requested by its id", func() {
+			dc, err := client.Get(ctx, &pb.GetRequest{Id: created.Id})
+			So(err, ShouldBeNil)
+			So(dc.Name, ShouldEqual, "grpc-dc")
+		})
+
+		Convey("Given a datacenter that does not exist", func() {
+			_, err := client.Get(ctx, &pb.GetRequest{Name: "unknown"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Given a datacenter is updated", func() {
+			dc, err := client.Set(ctx, &pb.SetRequest{Datacenter: &pb.Datacenter{Id: created.Id, Credentials: map[string]string{"username": "jane"}}, ExpectedVersion: 1})
+			So(err, ShouldBeNil)
+			So(dc.Version, ShouldEqual, 2)
+
+			_, err = client.Set(ctx, &pb.SetRequest{Datacenter: &pb.Datacenter{Id: created.Id}, ExpectedVersion: 1})
+			So(status.Code(err), ShouldEqual, codes.Aborted)
+		})
+
+		Convey("Given a credential is removed from a datacenter", func() {
+			dc, err := client.Set(ctx, &pb.SetRequest{Datacenter: &pb.Datacenter{Id: created.Id, Credentials: map[string]string{"region": "eu-west-1"}}, RemovedCredentials: []string{"username"}})
+			So(err, ShouldBeNil)
+			So(dc.Credentials, ShouldContainKey, "region")
+			So(dc.Credentials, ShouldNotContainKey, "username")
+		})
+
+		Convey("Given the deadline of a request is earlier than the request timeout", func() {
+			dctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+			defer cancel()
+			<-dctx.Done()
+
+			_, err := (&grpcServer{}).Get(dctx, &pb.GetRequest{Id: created.Id})
+			So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+		})
+
+		Convey("Given datacenters are searched", func() {
+			res, err := client.Find(ctx, &pb.FindRequest{Names: []string{"grpc-dc"}})
+			So(err, ShouldBeNil)
+			So(len(res.Datacenters), ShouldEqual, 1)
+			So(res.Total, ShouldEqual, 1)
+
+			res, err = client.Find(ctx, &pb.FindRequest{Limit: 10})
+			So(err, ShouldBeNil)
+			So(res.Total, ShouldEqual, 1)
+		})
+
+		Convey("Given a datacenter is deleted", func() {
+			res, err := client.Delete(ctx, &pb.DeleteRequest{Id: created.Id})
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, "deleted")
+
+			_, err = client.Get(ctx, &pb.GetRequest{Id: created.Id})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Given datacenter changes are watched", func() {
+			wctx, cancel := context.WithTimeout(ctx, time.Second*5)
+			defer cancel()
+
+			stream, err := client.Watch(wctx, &pb.WatchRequest{DatacenterId: created.Id, Operations: []string{"update"}})
+			So(err, ShouldBeNil)
+			time.Sleep(time.Millisecond * 100)
+
+			_, err = client.Set(ctx, &pb.SetRequest{Datacenter: &pb.Datacenter{Id: created.Id, Credentials: map[string]string{"username": "jane"}}})
+			So(err, ShouldBeNil)
+
+			ev, err := stream.Recv()
+			So(err, ShouldBeNil)
+			So(ev.Operation, ShouldEqual, "update")
+			So(ev.DatacenterId, ShouldEqual, created.Id)
+			So(ev.Datacenter.Name, ShouldEqual, "grpc-dc")
+		})
+	})
+}
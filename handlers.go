@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/go-nats"
+)
+
+// get : replies with the datacenter matching the given id or name
+func get(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx}
+	if err := e.loadFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, e)
+}
+
+// del : deletes the datacenter matching the given id or name
+func del(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx, subject: msg.Subject}
+	if err := e.loadFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if err := e.Delete(); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	send(msg, handler.DeletedMessage, nil)
+}
+
+// restore : restores the deleted datacenter matching the given id or name
+func restore(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx, subject: msg.Subject}
+	if err := e.loadDeletedFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if err := e.Restore(); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, e)
+}
+
+// purge : permanently deletes the datacenter matching the given id or
+// name, even if it was already deleted
+func purge(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx, subject: msg.Subject}
+	if err := e.loadDeletedFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if err := e.Purge(); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	send(msg, handler.DeletedMessage, nil)
+}
+
+// set : creates or updates a datacenter, replying with a validation
+// error when the provided credentials do not match the type's schema
+func set(ctx context.Context, msg *nats.Msg) {
+	var err error
+
+	e := Entity{ctx: ctx, subject: msg.Subject}
+	if err = e.mapInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if e.HasID() {
+		e = Entity{ctx: ctx, subject: msg.Subject}
+		if err = e.loadFromInput(msg.Data); err != nil {
+			fail(msg, err)
+			return
+		}
+		err = e.Update(msg.Data)
+	} else {
+		err = e.Save()
+	}
+
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, e)
+}
+
+// patch : updates the datacenter matching the given id or name, it
+// never creates a new one
+func patch(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx, subject: msg.Subject}
+	if err := e.loadFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if err := e.Update(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, e)
+}
+
+// find : replies with the list of datacenters matching the given fields
+func find(ctx context.Context, msg *nats.Msg) {
+	e := Entity{ctx: ctx}
+	if err := e.mapInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if e.paged() {
+		page, err := e.findPage()
+		if err != nil {
+			fail(msg, err)
+			return
+		}
+
+		respond(msg, page)
+		return
+	}
+
+	list, err := e.find()
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, list)
+}
+
+func respond(msg *nats.Msg, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	send(msg, body, nil)
+}
+
+func fail(msg *nats.Msg, err error) {
+	send(msg, nil, toError(err))
+}
+
+func reply(msg *nats.Msg, body []byte) {
+	if captured(msg, body) {
+		return
+	}
+	if msg.Reply == "" {
+		return
+	}
+	_ = n.Publish(msg.Reply, body)
+}
@@ -0,0 +1,255 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nats-io/go-nats"
+)
+
+// healthSubject : subject replying with the health of the store, it's
+// served outside the worker pools and the rbac policy so it can be probed
+// while the store is busy, and outside the queue group so every replica
+// replies
+const healthSubject = "datacenter.health"
+
+// probeTimeout : time each dependency has to reply to a health check
+const probeTimeout = time.Second * 2
+
+// health check statuses
+const (
+	healthOK       = "ok"
+	healthDown     = "down"
+	healthPending  = "pending"
+	healthDisabled = "disabled"
+)
+
+// ErrNotReady : the store is still connecting to the database or running
+// its migrations
+var ErrNotReady = &Error{Code: "503", Message: "Store is not ready", Retryable: true}
+
+// Health : state of the store and every dependency it needs to serve
+// requests
+type Health struct {
+	Status     string `json:"status"`
+	Instance   string `json:"instance"`
+	Nats       Check  `json:"nats"`
+	Database   Check  `json:"database"`
+	Migrations Check  `json:"migrations"`
+	Crypto     Check  `json:"crypto"`
+}
+
+// Check : state of a single dependency
+type Check struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// ready : determines if every dependency can be used
+func (h Health) ready() bool {
+	for _, c := range []Check{h.Nats, h.Database, h.Migrations, h.Crypto} {
+		if c.Status != healthOK && c.Status != healthDisabled {
+			return false
+		}
+	}
+	return true
+}
+
+// instance : name of the replica replying to health checks
+var instance, _ = os.Hostname()
+
+// migrations : status of the database migrations, pending until they run
+// successfully and down while they're being retried. It also guards the
+// database connection until the migrations run, as the health checks can
+// read it while it's being opened
+var migrations = struct {
+	sync.RWMutex
+	check Check
+}{check: Check{Status: healthPending}}
+
+func setMigrations(status string, err error) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	migrations.check = Check{Status: status}
+	if err != nil {
+		migrations.check.Error = err.Error()
+	}
+}
+
+// setDB : publishes the database connection to the health checks
+func setDB(d *gorm.DB) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	db = d
+}
+
+// currentDB : returns the database connection, nil until it's opened
+func currentDB() *gorm.DB {
+	migrations.RLock()
+	defer migrations.RUnlock()
+
+	return db
+}
+
+func migrated() bool {
+	migrations.RLock()
+	defer migrations.RUnlock()
+
+	return migrations.check.Status == healthOK
+}
+
+// checkHealth : checks every dependency of the store
+func checkHealth() Health {
+	h := Health{
+		Instance: instance,
+		Nats:     checkNats(),
+		Crypto:   probe(func() error { return checkKeyProvider(currentKeyProvider()) }),
+	}
+
+	migrations.RLock()
+	h.Migrations = migrations.check
+	d := db
+	migrations.RUnlock()
+
+	if d == nil {
+		h.Database = Check{Status: healthPending}
+	} else {
+		h.Database = probe(func() error { return d.DB().Ping() })
+	}
+
+	h.Status = healthOK
+	if !h.ready() {
+		h.Status = healthDown
+	}
+
+	return h
+}
+
+func checkNats() Check {
+	switch {
+	case !natsEnabled():
+		return Check{Status: healthDisabled}
+	case n == nil:
+		return Check{Status: healthPending}
+	case n.IsConnected():
+		return Check{Status: healthOK}
+	case n.IsReconnecting():
+		return Check{Status: healthDown, Error: "reconnecting"}
+	default:
+		return Check{Status: healthDown, Error: "disconnected"}
+	}
+}
+
+// probe : runs the given check measuring its latency, it fails if it
+// doesn't finish before the probe timeout
+func probe(f func() error) Check {
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- f()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(probeTimeout):
+		err = errors.New("timed out")
+	}
+
+	c := Check{
+		Status:  healthOK,
+		Latency: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		c.Status = healthDown
+		c.Error = err.Error()
+	}
+
+	return c
+}
+
+// health : replies with the health of the store
+func health(ctx context.Context, msg *nats.Msg) {
+	respond(msg, checkHealth())
+}
+
+func startHealth() {
+	s, err := n.Subscribe(healthSubject, func(msg *nats.Msg) {
+		envelope(health)(context.Background(), msg)
+	})
+	if err != nil {
+		log.Println("Error subscribing " + healthSubject)
+		return
+	}
+	subscriptions = append(subscriptions, s)
+}
+
+// probesServer : server of the liveness and readiness probes, if they're
+// served apart from the metrics
+var probesServer *http.Server
+
+func startProbes() {
+	addr := os.Getenv("ERNEST_PROBES_ADDR")
+	if addr == "" {
+		return
+	}
+
+	probesServer = &http.Server{Addr: addr, Handler: probesAPI()}
+
+	go func() {
+		log.Println("Serving probes on " + addr)
+		if err := probesServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// probesAPI : serves the liveness and readiness probes
+func probesAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", liveness)
+	mux.HandleFunc("/readyz", readiness)
+	return mux
+}
+
+// liveness : replies with the health of the store as long as it's running
+func liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, checkHealth(), http.StatusOK)
+}
+
+// readiness : replies with the health of the store, failing unless every
+// dependency can be used
+func readiness(w http.ResponseWriter, r *http.Request) {
+	h := checkHealth()
+
+	status := http.StatusOK
+	if !h.ready() {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, h, status)
+}
+
+func writeHealth(w http.ResponseWriter, h Health, status int) {
+	body, err := json.Marshal(h)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	write(w, status, body)
+}
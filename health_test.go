@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHealth(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_health")
+	setupPg("test_health")
+	startHandler()
+
+	api := httptest.NewServer(httpAPI())
+	defer api.Close()
+	probes := httptest.NewServer(metricsAPI())
+	defer probes.Close()
+
+	Convey("Scenario: checking the health of the store", t, func() {
+		Convey("Given the health is requested through nats", func() {
+			msg, err := n.Request(healthSubject, []byte(`{}`), time.Second)
+			So(err, ShouldBeNil)
+
+			h := Health{}
+			So(json.Unmarshal(msg.Data, &h), ShouldBeNil)
+
+			Convey("Then every dependency is reported", func() {
+				So(h.Status, ShouldEqual, healthOK)
+				So(h.Instance, ShouldEqual, instance)
+				So(h.Nats.Status, ShouldEqual, healthOK)
+				So(h.Database.Status, ShouldEqual, healthOK)
+				So(h.Database.Latency, ShouldBeGreaterThan, 0)
+				So(h.Migrations.Status, ShouldEqual, healthOK)
+				So(h.Crypto.Status, ShouldEqual, healthOK)
+			})
+		})
+
+		Convey("Given the health is requested with a versioned envelope", func() {
+			msg, err := n.Request(healthSubject, []byte(`{"api_version":"2","request_id":"health"}`), time.Second)
+			So(err, ShouldBeNil)
+
+			res := Response{}
+			So(json.Unmarshal(msg.Data, &res), ShouldBeNil)
+			So(res.RequestID, ShouldEqual, "health")
+			So(res.Error, ShouldBeNil)
+		})
+
+		Convey("Given the store is ready", func() {
+			res, err := http.Get(probes.URL + "/readyz")
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Given the probes are served apart from the metrics", func() {
+			own := httptest.NewServer(probesAPI())
+			defer own.Close()
+
+			Convey("Then they are served without the metrics", func() {
+				res, err := http.Get(own.URL + "/readyz")
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+				res, err = http.Get(own.URL + "/healthz")
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+				res, err = http.Get(own.URL + "/metrics")
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("Given the migrations are being retried", func() {
+			setMigrations(healthDown, errors.New("connection refused"))
+			defer setMigrations(healthOK, nil)
+
+			Convey("Then the store is not ready", func() {
+				res, err := http.Get(probes.URL + "/readyz")
+				So(err, ShouldBeNil)
+				defer func() { _ = res.Body.Close() }()
+				So(res.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+
+				h := Health{}
+				So(json.NewDecoder(res.Body).Decode(&h), ShouldBeNil)
+				So(h.Status, ShouldEqual, healthDown)
+				So(h.Migrations.Error, ShouldEqual, "connection refused")
+			})
+
+			Convey("Then the store is still alive", func() {
+				res, err := http.Get(probes.URL + "/healthz")
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then datacenter requests are rejected", func() {
+				res, err := http.Get(api.URL + "/datacenters")
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+				So(res.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+
+		Convey("Given a dependency does not reply in time", func() {
+			c := probe(func() error {
+				time.Sleep(probeTimeout + time.Second)
+				return nil
+			})
+			So(c.Status, ShouldEqual, healthDown)
+			So(c.Error, ShouldEqual, "timed out")
+		})
+	})
+}
@@ -0,0 +1,204 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxBodySize : maximum size of the http request bodies
+const maxBodySize = 1 << 20
+
+// callerHeader : header holding the json encoded caller of http requests
+const callerHeader = "X-Ernest-Caller"
+
+// listParams : find parameters accepting a comma separated list
+var listParams = []string{"ids", "names", "types", "fields"}
+
+// intParams : find parameters holding an integer
+var intParams = []string{"limit", "offset"}
+
+// boolParams : find parameters holding a boolean
+var boolParams = []string{"redact", "include_deleted"}
+
+// httpServer : server of the http api, if it's enabled
+var httpServer *http.Server
+
+func startHTTP() {
+	addr := os.Getenv("ERNEST_HTTP_ADDR")
+	if addr == "" {
+		return
+	}
+
+	httpServer = &http.Server{Addr: addr, Handler: httpAPI()}
+
+	go func() {
+		log.Println("Serving http api on " + addr)
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// httpAPI : serves the datacenter subjects as rest endpoints
+func httpAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/datacenters", datacenters)
+	mux.HandleFunc("/datacenters/", datacenter)
+	return mux
+}
+
+// datacenters : finds datacenters matching the query parameters on GET,
+// and creates or updates the given datacenter on POST
+func datacenters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		dispatch(w, r, "datacenter.find", query(r), http.StatusOK)
+	case http.MethodPost:
+		input, err := body(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		status := http.StatusCreated
+		if _, ok := input["id"]; ok {
+			status = http.StatusOK
+		}
+		dispatch(w, r, "datacenter.set", input, status)
+	default:
+		writeError(w, &Error{Code: "405", Message: "Method not allowed"})
+	}
+}
+
+// datacenter : gets, patches or deletes the datacenter matching the id
+// or name on the path
+func datacenter(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/datacenters/")
+	if key == "" || strings.Contains(key, "/") {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	input := map[string]interface{}{}
+	if r.Method == http.MethodPatch {
+		var err error
+		if input, err = body(w, r); err != nil {
+			writeError(w, err)
+			return
+		}
+		delete(input, "id")
+		delete(input, "name")
+	}
+
+	if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+		input["id"] = id
+	} else {
+		input["name"] = key
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		dispatch(w, r, "datacenter.get", input, http.StatusOK)
+	case http.MethodPatch:
+		dispatch(w, r, "datacenter.patch", input, http.StatusOK)
+	case http.MethodDelete:
+		dispatch(w, r, "datacenter.del", input, http.StatusOK)
+	default:
+		writeError(w, &Error{Code: "405", Message: "Method not allowed"})
+	}
+}
+
+// dispatch : runs the handler of the given subject with the request input
+// and writes its reply
+func dispatch(w http.ResponseWriter, r *http.Request, subject string, input map[string]interface{}, status int) {
+	if !migrated() {
+		writeError(w, ErrNotReady)
+		return
+	}
+
+	if c := r.Header.Get(callerHeader); c != "" {
+		var caller Caller
+		if err := json.Unmarshal([]byte(c), &caller); err != nil {
+			writeError(w, &Error{Code: "400", Message: "Invalid input", Field: callerHeader})
+			return
+		}
+		input["caller"] = caller
+	}
+
+	body, e := call(r.Context(), subject, input)
+	if e != nil {
+		status = e.Status()
+	}
+
+	write(w, status, body)
+}
+
+func body(w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, ErrInvalidInput
+	}
+
+	input := map[string]interface{}{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, ErrInvalidInput
+	}
+
+	return input, nil
+}
+
+// query : maps the query parameters to a datacenter.find request,
+// credentials are filtered with credentials.<key> parameters
+func query(r *http.Request) map[string]interface{} {
+	input := map[string]interface{}{}
+	credentials := map[string]interface{}{}
+
+	for k, values := range r.URL.Query() {
+		v := values[0]
+
+		switch {
+		case contains(listParams, k):
+			var list []string
+			for _, v := range values {
+				list = append(list, strings.Split(v, ",")...)
+			}
+			input[k] = list
+		case contains(intParams, k):
+			if i, err := strconv.Atoi(v); err == nil {
+				input[k] = i
+			}
+		case contains(boolParams, k):
+			input[k] = v == "true"
+		case strings.HasPrefix(k, "credentials."):
+			credentials[strings.TrimPrefix(k, "credentials.")] = v
+		default:
+			input[k] = v
+		}
+	}
+
+	if len(credentials) > 0 {
+		input["credentials"] = credentials
+	}
+
+	return input
+}
+
+func write(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e := toError(err)
+	write(w, e.Status(), e.Encoded())
+}
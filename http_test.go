@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPostgresURL(t *testing.T) {
+	Convey("Scenario: building the url of the database", t, func() {
+		Convey("Given the url has no sslmode", func() {
+			u, err := pgURL("postgres://postgres@127.0.0.1", "datacenters")
+			So(err, ShouldBeNil)
+			So(u, ShouldEqual, "postgres://postgres@127.0.0.1/datacenters?sslmode=disable")
+		})
+
+		Convey("Given the url sets its sslmode and other parameters", func() {
+			u, err := pgURL("postgres://postgres:secret@db:5432/?sslmode=verify-full&connect_timeout=5", "datacenters")
+			So(err, ShouldBeNil)
+			So(u, ShouldEqual, "postgres://postgres:secret@db:5432/datacenters?connect_timeout=5&sslmode=verify-full")
+		})
+
+		Convey("Given the url is not a postgres url", func() {
+			_, err := pgURL("127.0.0.1:5432", "datacenters")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHTTP(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_http")
+	setupPg("test_http")
+
+	api := httptest.NewServer(httpAPI())
+	defer api.Close()
+
+	request := func(method, path, body string, headers ...string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		So(err, ShouldBeNil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		defer func() { _ = res.Body.Close() }()
+
+		var data json.RawMessage
+		So(json.NewDecoder(res.Body).Decode(&data), ShouldBeNil)
+		return res, data
+	}
+
+	Convey("Scenario: mapping query parameters to a find request", t, func() {
+		req := httptest.NewRequest("GET", "/datacenters?names=a,b&names=c&limit=2&redact=true&type=aws&credentials.region=eu-west-1", nil)
+		input := query(req)
+		So(input["names"], ShouldResemble, []string{"a", "b", "c"})
+		So(input["limit"], ShouldEqual, 2)
+		So(input["redact"], ShouldEqual, true)
+		So(input["type"], ShouldEqual, "aws")
+		So(input["credentials"], ShouldResemble, map[string]interface{}{"region": "eu-west-1"})
+	})
+
+	Convey("Scenario: serving datacenters through the http api", t, func() {
+		setupTestSuite()
+
+		res, body := request("POST", "/datacenters", `{"name":"http-dc","type":"fake","credentials":{"username":"john"}}`)
+		So(res.StatusCode, ShouldEqual, http.StatusCreated)
+		So(res.Header.Get("Content-Type"), ShouldEqual, "application/json")
+		created := Entity{}
+		So(json.Unmarshal(body, &created), ShouldBeNil)
+		So(created.ID, ShouldNotEqual, 0)
+
+		Convey("Given a datacenter is requested by its id", func() {
+			res, body := request("GET", fmt.Sprintf("/datacenters/%d", created.ID), "")
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			output := Entity{}
+			So(json.Unmarshal(body, &output), ShouldBeNil)
+			So(output.Name, ShouldEqual, "http-dc")
+		})
+
+		Convey("Given a datacenter is requested by its name", func() {
+			res, _ := request("GET", "/datacenters/http-dc", "")
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Given a datacenter that does not exist", func() {
+			res, body := request("GET", "/datacenters/unknown", "")
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(string(body), ShouldEqual, string(ErrNotFound.Encoded()))
+		})
+
+		Convey("Given datacenters are searched", func() {
+			res, body := request("GET", "/datacenters?name=http-dc", "")
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			list := []Entity{}
+			So(json.Unmarshal(body, &list), ShouldBeNil)
+			So(len(list), ShouldEqual, 1)
+		})
+
+		Convey("Given a datacenter is patched", func() {
+			res, body := request("PATCH", fmt.Sprintf("/datacenters/%d", created.ID), `{"credentials":{"username":"jane"}}`)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			output := Entity{}
+			So(json.Unmarshal(body, &output), ShouldBeNil)
+			So(output.Version, ShouldEqual, 2)
+		})
+
+		Convey("Given a datacenter is deleted", func() {
+			res, body := request("DELETE", "/datacenters/http-dc", "")
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(body), ShouldEqual, `{"status":"deleted"}`)
+
+			res, _ = request("GET", "/datacenters/http-dc", "")
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Given a datacenter with invalid credentials", func() {
+			res, body := request("POST", "/datacenters", `{"name":"invalid-dc","type":"aws","credentials":{}}`)
+			So(res.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			output := Error{}
+			So(json.Unmarshal(body, &output), ShouldBeNil)
+			So(output.Code, ShouldEqual, "422")
+		})
+
+		Convey("Given an invalid body", func() {
+			res, _ := request("POST", "/datacenters", `{`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Given a request with a caller header", func() {
+			res, _ := request("GET", "/datacenters/http-dc", "", callerHeader, `{"user_id":99,"service":"api","token":"secret"}`)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileKeyProvider : loads the keyring from a json file, reloading it
+// whenever the file changes
+type FileKeyProvider struct {
+	*Keyring
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+type keyFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewFileKeyProvider : loads the keyring from the given file, with the
+// format {"primary":"2","keys":{"1":"...","2":"..."}}
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := FileKeyProvider{path: path, Keyring: &Keyring{keys: map[string]string{}}}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Reload : loads the keyring again if the file has changed
+func (p *FileKeyProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	k, err := NewKeyring(f.Primary, f.Keys[f.Primary], f.Keys)
+	if err != nil {
+		return err
+	}
+
+	p.Keyring.replace(k)
+	p.modTime = info.ModTime()
+
+	return nil
+}
+
+// Watch : checks the file for changes on the given interval, keeping
+// the current keys if the new file can't be loaded
+func (p *FileKeyProvider) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := p.Reload(); err != nil {
+				log.Println("could not reload key file: " + err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,211 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	aes "github.com/ernestio/crypto/aes"
+	"github.com/ernestio/datacenter-store/secrets"
+)
+
+// KeyProvider : provides the keys used to encrypt the data keys of
+// every credential value
+type KeyProvider interface {
+	// PrimaryID : returns the id of the key used to wrap new data keys
+	PrimaryID() (string, error)
+	// Wrap : encrypts a data key with the primary key
+	Wrap(dataKey string) (id string, wrapped string, err error)
+	// Unwrap : decrypts a data key encrypted with the given key
+	Unwrap(id, wrapped string) (string, error)
+}
+
+var keyProvider KeyProvider
+var keyProviderOnce sync.Once
+
+// currentKeyProvider : returns the configured key provider, defaulting
+// to the environment one
+func currentKeyProvider() KeyProvider {
+	keyProviderOnce.Do(func() {
+		if keyProvider != nil {
+			return
+		}
+		k, err := NewEnvKeyProvider()
+		if err != nil {
+			keyProvider = &Keyring{keys: map[string]string{}}
+			return
+		}
+		keyProvider = k
+	})
+
+	return keyProvider
+}
+
+// checkKeyProvider : verifies a data key can be wrapped and unwrapped
+// with the given provider
+func checkKeyProvider(p KeyProvider) error {
+	if _, err := p.PrimaryID(); err != nil {
+		return err
+	}
+
+	id, wrapped, err := p.Wrap("datacenter-store")
+	if err != nil {
+		return err
+	}
+
+	plain, err := p.Unwrap(id, wrapped)
+	if err != nil {
+		return err
+	}
+
+	if plain != "datacenter-store" {
+		return errors.New("key provider returned an invalid key")
+	}
+
+	return nil
+}
+
+// FallbackKeyProvider : wraps data keys with the primary provider, and
+// unwraps them with the fallback one when the primary can't, so values
+// encrypted before switching providers can still be read and rekeyed
+type FallbackKeyProvider struct {
+	Primary  KeyProvider
+	Fallback KeyProvider
+}
+
+// PrimaryID : returns the id of the primary key of the primary provider
+func (f *FallbackKeyProvider) PrimaryID() (string, error) {
+	return f.Primary.PrimaryID()
+}
+
+// Wrap : encrypts a data key with the primary provider
+func (f *FallbackKeyProvider) Wrap(dataKey string) (string, string, error) {
+	return f.Primary.Wrap(dataKey)
+}
+
+// Unwrap : decrypts a data key with the primary provider, or with the
+// fallback one if it fails
+func (f *FallbackKeyProvider) Unwrap(id, wrapped string) (string, error) {
+	plain, err := f.Primary.Unwrap(id, wrapped)
+	if err == nil {
+		return plain, nil
+	}
+
+	if plain, ferr := f.Fallback.Unwrap(id, wrapped); ferr == nil {
+		return plain, nil
+	}
+
+	return "", err
+}
+
+// Keyring : holds the keys used to encrypt credentials. Data keys are
+// always wrapped with the primary key, while any of the keys can be used
+// to unwrap them
+type Keyring struct {
+	sync.RWMutex
+	primary string
+	keys    map[string]string
+}
+
+// NewKeyring : creates a keyring with the given primary key and any
+// number of older keys used only for reading
+func NewKeyring(id, key string, old map[string]string) (*Keyring, error) {
+	if id == "" || strings.Contains(id, ":") {
+		return nil, errors.New("invalid key id " + id)
+	}
+	if key == "" {
+		return nil, errors.New("empty primary key " + id)
+	}
+
+	k := Keyring{primary: id, keys: map[string]string{id: key}}
+	for oid, okey := range old {
+		if oid == "" || strings.Contains(oid, ":") {
+			return nil, errors.New("invalid key id " + oid)
+		}
+		if okey == "" {
+			return nil, errors.New("empty key " + oid)
+		}
+		if oid != id {
+			k.keys[oid] = okey
+		}
+	}
+
+	return &k, nil
+}
+
+// NewEnvKeyProvider : builds a keyring from ERNEST_CRYPTO_KEY, its id
+// ERNEST_CRYPTO_KEY_ID and the comma separated id=key pairs on
+// ERNEST_CRYPTO_PREVIOUS_KEYS
+func NewEnvKeyProvider() (*Keyring, error) {
+	id := os.Getenv("ERNEST_CRYPTO_KEY_ID")
+	if id == "" {
+		id = secrets.LegacyKeyID
+	}
+
+	old := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("ERNEST_CRYPTO_PREVIOUS_KEYS"), ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("invalid previous key " + kv[0])
+		}
+		old[kv[0]] = kv[1]
+	}
+
+	return NewKeyring(id, os.Getenv("ERNEST_CRYPTO_KEY"), old)
+}
+
+// PrimaryID : returns the id of the primary key
+func (k *Keyring) PrimaryID() (string, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	if k.primary == "" {
+		return "", errors.New("no encryption key configured")
+	}
+
+	return k.primary, nil
+}
+
+// Wrap : encrypts a data key with the primary key
+func (k *Keyring) Wrap(dataKey string) (string, string, error) {
+	k.RLock()
+	id, key := k.primary, k.keys[k.primary]
+	k.RUnlock()
+
+	if key == "" {
+		return "", "", errors.New("no encryption key configured")
+	}
+
+	wrapped, err := aes.New().Encrypt(dataKey, key)
+
+	return id, wrapped, err
+}
+
+// Unwrap : decrypts a data key with the key matching the given id
+func (k *Keyring) Unwrap(id, wrapped string) (string, error) {
+	k.RLock()
+	key, ok := k.keys[id]
+	k.RUnlock()
+
+	if !ok {
+		return "", errors.New("unknown key " + id)
+	}
+
+	return aes.New().Decrypt(wrapped, key)
+}
+
+func (k *Keyring) replace(o *Keyring) {
+	k.Lock()
+	defer k.Unlock()
+
+	k.primary = o.primary
+	k.keys = o.keys
+}
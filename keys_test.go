@@ -0,0 +1,167 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// transitStandIn : minimal vault transit api, wrapping keys by reversing
+// their base64 encoding
+func transitStandIn() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/transit/keys/test":
+			_, _ = w.Write([]byte(`{"data":{"latest_version":3}}`))
+		case "/v1/transit/encrypt/test":
+			_, _ = w.Write([]byte(`{"data":{"ciphertext":"vault:v3:` + reverse(body["plaintext"]) + `"}}`))
+		case "/v1/transit/decrypt/test":
+			parts := strings.SplitN(body["ciphertext"], ":", 3)
+			_, _ = w.Write([]byte(`{"data":{"plaintext":"` + reverse(parts[2]) + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func TestKeyProviders(t *testing.T) {
+	Convey("Scenario: loading keys from the environment", t, func() {
+		defer func(key string) { _ = os.Setenv("ERNEST_CRYPTO_KEY", key) }(os.Getenv("ERNEST_CRYPTO_KEY"))
+
+		Convey("Given no key is configured", func() {
+			_ = os.Setenv("ERNEST_CRYPTO_KEY", "")
+			_, err := NewEnvKeyProvider()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Given a key is configured", func() {
+			_ = os.Setenv("ERNEST_CRYPTO_KEY", oldTestKey)
+			p, err := NewEnvKeyProvider()
+			So(err, ShouldBeNil)
+			So(checkKeyProvider(p), ShouldBeNil)
+		})
+	})
+
+	Convey("Scenario: loading keys from a file", t, func() {
+		f, err := ioutil.TempFile("", "keys")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+
+		err = ioutil.WriteFile(f.Name(), []byte(`{"primary":"1","keys":{"1":"`+oldTestKey+`"}}`), 0600)
+		So(err, ShouldBeNil)
+
+		p, err := NewFileKeyProvider(f.Name())
+		So(err, ShouldBeNil)
+		So(checkKeyProvider(p), ShouldBeNil)
+		id, wrapped, _ := p.Wrap("data-key")
+		So(id, ShouldEqual, "1")
+
+		Convey("Given the file changes", func() {
+			err = ioutil.WriteFile(f.Name(), []byte(`{"primary":"2","keys":{"1":"`+oldTestKey+`","2":"`+newTestKey+`"}}`), 0600)
+			So(err, ShouldBeNil)
+			So(os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Minute)), ShouldBeNil)
+
+			Convey("Then the new keys should be loaded", func() {
+				So(p.Reload(), ShouldBeNil)
+				primary, _ := p.PrimaryID()
+				So(primary, ShouldEqual, "2")
+
+				plain, err := p.Unwrap(id, wrapped)
+				So(err, ShouldBeNil)
+				So(plain, ShouldEqual, "data-key")
+			})
+		})
+
+		Convey("Given the file is not valid", func() {
+			err = ioutil.WriteFile(f.Name(), []byte(`{"primary":"3","keys":{}}`), 0600)
+			So(err, ShouldBeNil)
+			So(os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Minute)), ShouldBeNil)
+
+			Convey("Then the current keys should be kept", func() {
+				So(p.Reload(), ShouldNotBeNil)
+				primary, _ := p.PrimaryID()
+				So(primary, ShouldEqual, "1")
+			})
+		})
+	})
+
+	Convey("Scenario: wrapping keys through vault transit", t, func() {
+		s := transitStandIn()
+		defer s.Close()
+
+		Convey("Given a valid token", func() {
+			p := NewVaultKeyProvider(s.URL, "token", "test")
+			So(checkKeyProvider(p), ShouldBeNil)
+
+			id, _, err := p.Wrap("data-key")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "vault.3")
+		})
+
+		Convey("Given an invalid token", func() {
+			p := NewVaultKeyProvider(s.URL, "invalid", "test")
+			So(checkKeyProvider(p), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Scenario: switching from a keyring to vault transit", t, func() {
+		s := transitStandIn()
+		defer s.Close()
+
+		old, err := NewKeyring("1", oldTestKey, nil)
+		So(err, ShouldBeNil)
+		oid, owrapped, err := old.Wrap("legacy-key")
+		So(err, ShouldBeNil)
+
+		p := &FallbackKeyProvider{Primary: NewVaultKeyProvider(s.URL, "token", "test"), Fallback: old}
+		So(checkKeyProvider(p), ShouldBeNil)
+
+		Convey("Then new data keys are wrapped through vault", func() {
+			id, wrapped, err := p.Wrap("data-key")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "vault.3")
+
+			plain, err := p.Unwrap(id, wrapped)
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "data-key")
+		})
+
+		Convey("Then data keys wrapped by the keyring can still be unwrapped", func() {
+			plain, err := p.Unwrap(oid, owrapped)
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "legacy-key")
+		})
+
+		Convey("Then unknown keys are reported by the primary provider", func() {
+			_, err := p.Unwrap("9", owrapped)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
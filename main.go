@@ -6,7 +6,8 @@ package main
 
 import (
 	"log"
-	"runtime"
+	"os"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nats-io/go-nats"
@@ -18,37 +19,94 @@ import (
 var n *nats.Conn
 var db *gorm.DB
 var err error
-var handler natsdb.Handler
 
-func startHandler() {
-	handler = natsdb.Handler{
-		NotFoundErrorMessage:   natsdb.NotFound.Encoded(),
-		UnexpectedErrorMessage: natsdb.Unexpected.Encoded(),
-		DeletedMessage:         []byte(`{"status":"deleted"}`),
-		Nats:                   n,
-		NewModel: func() natsdb.Model {
-			return &Entity{}
-		},
-	}
+// queueGroup : nats queue group every replica subscribes with, so each
+// request is handled by a single replica
+var queueGroup = "datacenter-store"
 
-	if _, err = n.Subscribe("datacenter.get", handler.Get); err != nil {
-		log.Println("Error subscribing datacenter.get")
-	}
-	if _, err = n.Subscribe("datacenter.del", handler.Del); err != nil {
-		log.Println("Error subscribing datacenter.del")
-	}
-	if _, err = n.Subscribe("datacenter.set", handler.Set); err != nil {
-		log.Println("Error subscribing datacenter.set")
+var handler = natsdb.Handler{
+	NotFoundErrorMessage:   ErrNotFound.Encoded(),
+	UnexpectedErrorMessage: ErrUnexpected.Encoded(),
+	DeletedMessage:         []byte(`{"status":"deleted"}`),
+	NewModel: func() natsdb.Model {
+		return &Entity{}
+	},
+}
+
+// route : handler serving a datacenter subject
+type route struct {
+	subject string
+	handler handlerFunc
+}
+
+var routes = []route{
+	{"datacenter.get", get},
+	{"datacenter.del", del},
+	{"datacenter.restore", restore},
+	{"datacenter.purge", purge},
+	{"datacenter.set", set},
+	{"datacenter.patch", patch},
+	{"datacenter.find", find},
+	{"datacenter.audit.find", findAudit},
+	{"datacenter.get.decrypted", getDecrypted},
+	{"datacenter.credentials", classification},
+	{"datacenter.rekey", rekey},
+	{"datacenter.schema", schema},
+}
+
+// serve : returns the handler for the given subject, queuing requests on
+// its worker pool, unwrapping versioned requests and enforcing the rbac
+// policy
+func serve(subject string) nats.MsgHandler {
+	for _, r := range routes {
+		if r.subject == subject {
+			return poolFor(subject, envelope(authorize(r.handler))).handle
+		}
 	}
-	if _, err = n.Subscribe("datacenter.find", handler.Find); err != nil {
-		log.Println("Error subscribing datacenter.find")
+	return nil
+}
+
+func startHandler() {
+	handler.Nats = n
+
+	subscribe(n)
+	startHealth()
+}
+
+// subscribe : subscribes the datacenter subjects on the queue group
+// through the given connection
+func subscribe(conn *nats.Conn) {
+	for _, r := range routes {
+		s, err := conn.QueueSubscribe(r.subject, queueGroup, serve(r.subject))
+		if err != nil {
+			log.Println("Error subscribing " + r.subject)
+			continue
+		}
+		subscriptions = append(subscriptions, s)
 	}
 }
 
 func main() {
-	setupNats()
+	if natsEnabled() {
+		setupNats()
+		setupQueueGroup()
+	}
+	setupRequestTimeout()
+	setupClassification()
+	setupKeyProvider()
+	setupServices()
+	setupTenancy()
+	setupPolicy()
+	startHTTP()
+	startMetrics()
+	startProbes()
 	setupPg("projects")
-	startHandler()
+	if natsEnabled() {
+		startHandler()
+		startOutbox(time.Second * 10)
+	}
+	startGRPC()
+	startReaper()
 
-	runtime.Goexit()
+	os.Exit(waitForSignal())
 }
@@ -0,0 +1,224 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nats-io/go-nats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace : prefix of every metric name
+const metricsNamespace = "datacenter_store"
+
+// countTimeout : time the datacenters are counted for on each scrape
+const countTimeout = time.Second * 2
+
+// request outcomes, by the code of the error replied
+var outcomes = map[string]string{
+	"400": "validation",
+	"401": "denied",
+	"403": "denied",
+	"404": "not_found",
+	"409": "conflict",
+	"422": "validation",
+	"429": "unavailable",
+	"503": "unavailable",
+	"504": "unavailable",
+}
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Requests handled on each subject by outcome.",
+	}, []string{"subject", "outcome"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle the requests of each subject by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subject", "outcome"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_duration_seconds",
+		Help:      "Time taken by database reads and write transactions.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	cryptoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "crypto_duration_seconds",
+		Help:      "Time taken to encrypt or decrypt a credential value.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"operation"})
+
+	datacentersDesc = prometheus.NewDesc(
+		metricsNamespace+"_datacenters",
+		"Datacenters stored by type, deleted ones are not counted.",
+		[]string{"type"}, nil,
+	)
+)
+
+// registry : collectors exposed on the metrics endpoint
+var registry = func() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(requestsTotal, requestDuration, dbDuration, cryptoDuration, datacenterCounts{})
+	return r
+}()
+
+// metricsServer : server of the metrics endpoint, if it's enabled
+var metricsServer *http.Server
+
+func startMetrics() {
+	addr := os.Getenv("ERNEST_METRICS_ADDR")
+	if addr == "" {
+		return
+	}
+
+	metricsServer = &http.Server{Addr: addr, Handler: metricsAPI()}
+
+	go func() {
+		log.Println("Serving metrics on " + addr)
+		if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// metricsAPI : serves the metrics on the prometheus text format, along
+// with the liveness and readiness probes
+func metricsAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", liveness)
+	mux.HandleFunc("/readyz", readiness)
+	return mux
+}
+
+// outcome : error replied to a request, nil while it succeeds
+type outcome struct {
+	err *Error
+}
+
+// label : outcome label of the reply
+func (o *outcome) label() string {
+	if o.err == nil {
+		return "ok"
+	}
+	if l, ok := outcomes[o.err.Code]; ok {
+		return l
+	}
+	return "unexpected"
+}
+
+// pending : outcomes of the requests being handled by a worker
+var pending sync.Map
+
+// track : records the outcome of the request until untrack is called
+func track(msg *nats.Msg) *outcome {
+	o := &outcome{}
+	pending.Store(msg, o)
+	return o
+}
+
+func untrack(msg *nats.Msg) {
+	pending.Delete(msg)
+}
+
+// follow : records the outcome of the request on the outcome of the one
+// it was unwrapped from
+func follow(from, to *nats.Msg) {
+	if v, ok := pending.Load(from); ok {
+		pending.Store(to, v)
+	}
+}
+
+// record : stores the error replied to a tracked request
+func record(msg *nats.Msg, e *Error) {
+	if v, ok := pending.Load(msg); ok {
+		v.(*outcome).err = e
+	}
+}
+
+// observeRequest : counts a request on its subject and outcome, along with
+// the time taken to handle it
+func observeRequest(subject string, o *outcome, start time.Time) {
+	l := o.label()
+	requestsTotal.WithLabelValues(subject, l).Inc()
+	requestDuration.WithLabelValues(subject, l).Observe(time.Since(start).Seconds())
+}
+
+// observeRejected : counts a request rejected before being handled
+func observeRejected(subject string, e *Error) {
+	requestsTotal.WithLabelValues(subject, (&outcome{err: e}).label()).Inc()
+}
+
+// observeDB : observes the time taken by a database operation since start
+func observeDB(operation string, start time.Time) {
+	dbDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeCrypto : observes the time taken to encrypt or decrypt a value
+// since start
+func observeCrypto(operation string, start time.Time) {
+	cryptoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// datacenterCounts : counts the stored datacenters by type on every
+// scrape, so every replica reports the totals of the shared database. The
+// count is bounded by countTimeout so a slow database can't hold scrapes
+type datacenterCounts struct{}
+
+func (datacenterCounts) Describe(ch chan<- *prometheus.Desc) {
+	ch <- datacentersDesc
+}
+
+func (datacenterCounts) Collect(ch chan<- prometheus.Metric) {
+	// the database can only be used once it's migrated
+	if !migrated() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+
+	counts := map[string]float64{}
+	err := session(ctx, func(tx *gorm.DB) error {
+		rows, err := tx.Model(&Entity{}).Select("type, count(*)").Group("type").Rows()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+
+		for rows.Next() {
+			var t string
+			var count float64
+			if err := rows.Scan(&t, &count); err != nil {
+				return err
+			}
+			counts[t] = count
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Println("could not count datacenters: " + err.Error())
+		return
+	}
+
+	for t, count := range counts {
+		ch <- prometheus.MustNewConstMetric(datacentersDesc, prometheus.GaugeValue, count, t)
+	}
+}
@@ -0,0 +1,79 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_metrics")
+	setupPg("test_metrics")
+	startHandler()
+
+	api := httptest.NewServer(metricsAPI())
+	defer api.Close()
+
+	scrape := func() string {
+		res, err := http.Get(api.URL + "/metrics")
+		So(err, ShouldBeNil)
+		defer func() { _ = res.Body.Close() }()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+		body, err := ioutil.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		return string(body)
+	}
+
+	Convey("Scenario: labelling request outcomes", t, func() {
+		So((&outcome{}).label(), ShouldEqual, "ok")
+		So((&outcome{err: ErrNotFound}).label(), ShouldEqual, "not_found")
+		So((&outcome{err: ErrInvalidInput}).label(), ShouldEqual, "validation")
+		So((&outcome{err: ErrBusy}).label(), ShouldEqual, "unavailable")
+		So((&outcome{err: ErrUnexpected}).label(), ShouldEqual, "unexpected")
+	})
+
+	Convey("Scenario: exposing the store metrics", t, func() {
+		setupTestSuite()
+		createVcloudEntities(2)
+
+		_, err := n.Request("datacenter.get", []byte(`{"name":"TestVcloud0"}`), time.Second)
+		So(err, ShouldBeNil)
+		_, err = n.Request("datacenter.get", []byte(`{"name":"missing"}`), time.Second)
+		So(err, ShouldBeNil)
+		_, err = n.Request("datacenter.set", []byte(`{"name":"metrics-dc","type":"fake","credentials":{"username":"john","password":"secret"}}`), time.Second)
+		So(err, ShouldBeNil)
+
+		body := scrape()
+
+		Convey("Then requests are counted by subject and outcome", func() {
+			So(body, ShouldContainSubstring, `datacenter_store_requests_total{outcome="ok",subject="datacenter.get"}`)
+			So(body, ShouldContainSubstring, `datacenter_store_requests_total{outcome="not_found",subject="datacenter.get"}`)
+			So(body, ShouldContainSubstring, `datacenter_store_request_duration_seconds_count{outcome="ok",subject="datacenter.set"}`)
+		})
+
+		Convey("Then database and encryption durations are observed", func() {
+			So(body, ShouldContainSubstring, `datacenter_store_db_duration_seconds_count{operation="write"}`)
+			So(body, ShouldContainSubstring, `datacenter_store_crypto_duration_seconds_count{operation="encrypt"}`)
+		})
+
+		Convey("Then datacenters are counted by type", func() {
+			So(body, ShouldContainSubstring, `datacenter_store_datacenters{type="vcloud"} 2`)
+			So(body, ShouldContainSubstring, `datacenter_store_datacenters{type="fake"} 1`)
+		})
+	})
+}
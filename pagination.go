@@ -0,0 +1,193 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// orderColumns : columns datacenters can be sorted by
+var orderColumns = []string{"name", "created_at", "updated_at"}
+
+// Page : a page of datacenters along with the total of matching ones
+type Page struct {
+	Items  []interface{} `json:"items"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset,omitempty"`
+	Next   string        `json:"next,omitempty"`
+}
+
+// cursor : position of the last datacenter of a page, encoded as the
+// after token to request the next one
+type cursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// paged : determines if any paging field was requested
+func (e *Entity) paged() bool {
+	return e.Limit > 0 || e.Offset > 0 || e.After != ""
+}
+
+// orderBy : returns the column and direction to sort by, order_by
+// accepts any of the order columns prefixed by - for descending order
+func (e *Entity) orderBy() (string, bool, error) {
+	if e.OrderBy == "" {
+		return "id", false, nil
+	}
+
+	column := strings.TrimPrefix(e.OrderBy, "-")
+	if !contains(orderColumns, column) {
+		return "", false, &Error{Code: "400", Message: "Invalid order", Field: "order_by"}
+	}
+
+	return column, strings.HasPrefix(e.OrderBy, "-"), nil
+}
+
+// order : sorts the query by the requested column, using the id to break
+// ties so pages are stable
+func (e *Entity) order(q *gorm.DB) (*gorm.DB, error) {
+	column, desc, err := e.orderBy()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := " asc"
+	if desc {
+		dir = " desc"
+	}
+
+	if column == "id" {
+		return q.Order("id" + dir), nil
+	}
+
+	return q.Order(column + dir).Order("id" + dir), nil
+}
+
+// findPage : returns a page of the datacenters matching the search
+// fields, either by offset or after the position of a previous page
+func (e *Entity) findPage() (*Page, error) {
+	limit := e.Limit
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	var total int
+	entities := []Entity{}
+
+	err := session(e.ctx, func(tx *gorm.DB) error {
+		q, err := e.filter(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := q.Count(&total).Error; err != nil {
+			return dbError(err)
+		}
+
+		if q, err = e.order(q); err != nil {
+			return err
+		}
+
+		if e.After != "" {
+			if q, err = e.after(q); err != nil {
+				return err
+			}
+		} else if e.Offset > 0 {
+			q = q.Offset(e.Offset)
+		}
+
+		return dbError(q.Limit(limit).Find(&entities).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := e.present(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Page{Items: items, Total: total, Limit: limit, Offset: e.Offset}
+	if e.After != "" {
+		p.Offset = 0
+	}
+
+	if len(entities) == limit {
+		p.Next = e.cursor(entities[len(entities)-1])
+	}
+
+	return &p, nil
+}
+
+// cursor : encodes the position of the given entity
+func (e *Entity) cursor(last Entity) string {
+	column, _, _ := e.orderBy()
+
+	c := cursor{ID: last.ID}
+	switch column {
+	case "name":
+		c.Value = last.Name
+	case "created_at":
+		c.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		c.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+	}
+
+	body, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// after : restricts the query to the datacenters after the cursor
+func (e *Entity) after(q *gorm.DB) (*gorm.DB, error) {
+	invalid := &Error{Code: "400", Message: "Invalid cursor", Field: "after"}
+
+	body, err := base64.RawURLEncoding.DecodeString(e.After)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var c cursor
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, invalid
+	}
+
+	column, desc, _ := e.orderBy()
+
+	op := " > "
+	if desc {
+		op = " < "
+	}
+
+	if column == "id" {
+		return q.Where("id"+op+"?", c.ID), nil
+	}
+
+	var value interface{} = c.Value
+	if column != "name" {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, invalid
+		}
+		value = t
+	}
+
+	return q.Where("("+column+", id)"+op+"(?, ?)", value, c.ID), nil
+}
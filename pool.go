@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+const (
+	// defaultWorkers : workers handling the requests of each subject
+	defaultWorkers = 8
+	// defaultQueueSize : requests of each subject waiting for a worker
+	// before new ones are rejected as busy
+	defaultQueueSize = 64
+	// defaultRequestTimeout : time a request can take since it's received,
+	// including the time it waits for a worker
+	defaultRequestTimeout = time.Second * 10
+)
+
+// handlerFunc : handles a request until its context is done
+type handlerFunc func(ctx context.Context, msg *nats.Msg)
+
+// ErrBusy : every worker of the subject is busy and its queue is full
+var ErrBusy = &Error{Code: "429", Message: "Store is busy", Retryable: true}
+
+// ErrTimeout : the request could not be handled before its timeout
+var ErrTimeout = &Error{Code: "504", Message: "Request timed out", Retryable: true}
+
+// requestTimeout : time a request can take since it's received
+var requestTimeout = defaultRequestTimeout
+
+// job : request waiting for a worker
+type job struct {
+	msg      *nats.Msg
+	deadline time.Time
+}
+
+// pool : workers handling the requests of a subject from a bounded queue
+type pool struct {
+	subject string
+	handler handlerFunc
+	jobs    chan job
+}
+
+var pools = make(map[string]*pool)
+var poolsMu sync.Mutex
+
+// poolFor : returns the pool of the given subject, starting it the
+// first time it's requested
+func poolFor(subject string, h handlerFunc) *pool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	p, ok := pools[subject]
+	if !ok {
+		p = newPool(subject, h, poolSetting(subject, "WORKERS", defaultWorkers), poolSetting(subject, "QUEUE_SIZE", defaultQueueSize))
+		pools[subject] = p
+	}
+
+	return p
+}
+
+// newPool : starts the given number of workers for the subject
+func newPool(subject string, h handlerFunc, workers, size int) *pool {
+	p := pool{
+		subject: subject,
+		handler: h,
+		jobs:    make(chan job, size),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return &p
+}
+
+// handle : queues the request, rejecting it if the queue is full or the
+// store is shutting down
+func (p *pool) handle(msg *nats.Msg) {
+	if !admit() {
+		observeRejected(p.subject, ErrShuttingDown)
+		reject(msg, ErrShuttingDown)
+		return
+	}
+
+	deadline := time.Now().Add(requestTimeout)
+	if d, ok := callDeadline(msg); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	select {
+	case p.jobs <- job{msg: msg, deadline: deadline}:
+	default:
+		inflight.Done()
+		log.Println("Rejecting request on " + p.subject + ", every worker is busy")
+		observeRejected(p.subject, ErrBusy)
+		reject(msg, ErrBusy)
+	}
+}
+
+func (p *pool) work() {
+	for j := range p.jobs {
+		p.run(j)
+	}
+}
+
+func (p *pool) run(j job) {
+	defer inflight.Done()
+
+	o := track(j.msg)
+	defer untrack(j.msg)
+	defer observeRequest(p.subject, o, time.Now())
+
+	ctx, cancel := context.WithDeadline(context.Background(), j.deadline)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		reject(j.msg, ErrTimeout)
+		return
+	}
+
+	p.handler(ctx, j.msg)
+}
+
+// reject : replies with the given error, on a response envelope if the
+// request was received on one
+func reject(msg *nats.Msg, err error) {
+	envelope(func(ctx context.Context, m *nats.Msg) {
+		fail(m, err)
+	})(context.Background(), msg)
+}
+
+// poolSetting : reads a pool setting from the environment, i.e.
+// ERNEST_WORKERS_DATACENTER_FIND for the workers of datacenter.find,
+// falling back to ERNEST_WORKERS for every subject
+func poolSetting(subject, name string, def int) int {
+	keys := []string{
+		"ERNEST_" + name + "_" + strings.ToUpper(strings.Replace(subject, ".", "_", -1)),
+		"ERNEST_" + name,
+	}
+
+	for _, k := range keys {
+		v := os.Getenv(k)
+		if v == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 {
+			log.Println("invalid " + k + " " + v + ", using the default value")
+			return def
+		}
+		return i
+	}
+
+	return def
+}
@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// localRequest : sends a request to the pool, returning the reply once
+// it's handled
+func localRequest(p *pool, reply string) *inboxReply {
+	res := &inboxReply{done: make(chan struct{})}
+	inboxes.Store(localInbox+reply, res)
+	p.handle(&nats.Msg{Subject: p.subject, Reply: localInbox + reply, Data: []byte(`{}`)})
+	return res
+}
+
+func TestPool(t *testing.T) {
+	Convey("Scenario: handling requests on a worker pool", t, func() {
+		release := make(chan struct{})
+		deadlines := make(chan time.Time, 10)
+
+		p := newPool("datacenter.test", func(ctx context.Context, msg *nats.Msg) {
+			d, _ := ctx.Deadline()
+			deadlines <- d
+			<-release
+			respond(msg, map[string]string{"status": "ok"})
+		}, 1, 1)
+
+		Convey("Given a request is handled", func() {
+			res := localRequest(p, "pool.ok")
+			close(release)
+			<-res.done
+
+			Convey("Then the handler gets the request deadline", func() {
+				d := <-deadlines
+				So(time.Until(d), ShouldBeGreaterThan, 0)
+				So(time.Until(d), ShouldBeLessThanOrEqualTo, requestTimeout)
+				So(res.err, ShouldBeNil)
+				So(string(res.body), ShouldEqual, `{"status":"ok"}`)
+			})
+		})
+
+		Convey("Given every worker is busy and the queue is full", func() {
+			first := localRequest(p, "pool.first")
+			<-deadlines
+			queued := localRequest(p, "pool.queued")
+			rejected := localRequest(p, "pool.rejected")
+			<-rejected.done
+			close(release)
+			<-first.done
+			<-queued.done
+
+			Convey("Then the request is rejected as busy", func() {
+				So(rejected.err, ShouldEqual, ErrBusy)
+				So(first.err, ShouldBeNil)
+				So(queued.err, ShouldBeNil)
+			})
+		})
+
+		Convey("Given a request waits for a worker longer than its timeout", func() {
+			timeout := requestTimeout
+			requestTimeout = time.Millisecond * 50
+			defer func() { requestTimeout = timeout }()
+
+			first := localRequest(p, "pool.slow")
+			<-deadlines
+			queued := localRequest(p, "pool.expired")
+			time.Sleep(time.Millisecond * 100)
+			close(release)
+			<-first.done
+			<-queued.done
+
+			Convey("Then the request is rejected as timed out", func() {
+				So(queued.err, ShouldEqual, ErrTimeout)
+			})
+		})
+	})
+
+	Convey("Scenario: reading pool settings", t, func() {
+		defer func() {
+			_ = os.Unsetenv("ERNEST_WORKERS")
+			_ = os.Unsetenv("ERNEST_WORKERS_DATACENTER_FIND")
+		}()
+
+		Convey("Given no setting is defined", func() {
+			So(poolSetting("datacenter.find", "WORKERS", 8), ShouldEqual, 8)
+		})
+
+		Convey("Given a setting for every subject", func() {
+			_ = os.Setenv("ERNEST_WORKERS", "4")
+			So(poolSetting("datacenter.find", "WORKERS", 8), ShouldEqual, 4)
+			So(poolSetting("datacenter.get", "WORKERS", 8), ShouldEqual, 4)
+		})
+
+		Convey("Given a setting for a single subject", func() {
+			_ = os.Setenv("ERNEST_WORKERS", "4")
+			_ = os.Setenv("ERNEST_WORKERS_DATACENTER_FIND", "16")
+			So(poolSetting("datacenter.find", "WORKERS", 8), ShouldEqual, 16)
+			So(poolSetting("datacenter.get", "WORKERS", 8), ShouldEqual, 4)
+		})
+
+		Convey("Given an invalid setting", func() {
+			_ = os.Setenv("ERNEST_WORKERS", "none")
+			So(poolSetting("datacenter.find", "WORKERS", 8), ShouldEqual, 8)
+		})
+	})
+}
@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func natsURI() string {
+	if uri := os.Getenv("NATS_URI"); uri != "" {
+		return uri
+	}
+	return nats.DefaultURL
+}
+
+func TestQueueGroup(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_queue")
+	setupPg("test_queue")
+
+	Convey("Scenario: running several instances", t, func() {
+		setupTestSuite()
+		createEntities(1)
+
+		// each instance subscribes through its own connection, as
+		// replicas do
+		instances := make([]*nats.Conn, 3)
+		for i := range instances {
+			conn, err := nats.Connect(natsURI())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			subscribe(conn)
+			So(conn.Flush(), ShouldBeNil)
+			instances[i] = conn
+		}
+
+		Convey("Given requests are sent to the store", func() {
+			inbox := nats.NewInbox()
+			sub, err := n.SubscribeSync(inbox)
+			So(err, ShouldBeNil)
+			defer func() { _ = sub.Unsubscribe() }()
+
+			for i := 0; i < 20; i++ {
+				So(n.PublishRequest("datacenter.get", inbox, []byte(`{"name":"Test0"}`)), ShouldBeNil)
+			}
+
+			replies := 0
+			for {
+				if _, err := sub.NextMsg(time.Millisecond * 500); err != nil {
+					break
+				}
+				replies++
+			}
+
+			var handled uint64
+			receiving := 0
+			for _, conn := range instances {
+				received := conn.Stats().InMsgs
+				handled += received
+				if received > 0 {
+					receiving++
+				}
+			}
+
+			Convey("Then each request is handled by exactly one instance", func() {
+				So(handled, ShouldEqual, 20)
+				So(replies, ShouldEqual, 20)
+			})
+
+			Convey("Then the requests are shared between the instances", func() {
+				So(receiving, ShouldBeGreaterThan, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,103 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/nats-io/go-nats"
+)
+
+// Policy : subjects each role is allowed to request, subjects ending
+// with * match any subject with the same prefix
+type Policy struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// policy : rbac policy enforced on every subject, requests are not
+// restricted if it's not set
+var policy *Policy
+
+// DefaultPolicy : policy for the reader, editor and admin roles
+var DefaultPolicy = Policy{
+	Roles: map[string][]string{
+		"reader": {"datacenter.get", "datacenter.find", "datacenter.credentials", "datacenter.schema", "datacenter.watch"},
+		"editor": {"datacenter.get", "datacenter.find", "datacenter.credentials", "datacenter.schema", "datacenter.watch", "datacenter.set", "datacenter.patch", "datacenter.del", "datacenter.restore"},
+		"admin":  {"*"},
+	},
+}
+
+// loadPolicy : loads the rbac policy from a json file with the format
+// {"roles":{"reader":["datacenter.get","datacenter.find"],"admin":["*"]}}
+func loadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// allows : determines if any of the given roles can request the subject
+func (p *Policy) allows(roles []string, subject string) bool {
+	for _, role := range roles {
+		for _, s := range p.Roles[role] {
+			if s == subject || strings.HasSuffix(s, "*") && strings.HasPrefix(subject, strings.TrimSuffix(s, "*")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// authorize : evaluates the policy against the roles of the request
+// caller before running the handler
+func authorize(h handlerFunc) handlerFunc {
+	return func(ctx context.Context, msg *nats.Msg) {
+		if policy == nil {
+			h(ctx, msg)
+			return
+		}
+
+		caller, err := callerOf(msg.Data)
+		if err == nil {
+			err = permit(caller, msg.Subject)
+		}
+		if err != nil {
+			fail(msg, err)
+			return
+		}
+
+		h(ctx, msg)
+	}
+}
+
+// permit : evaluates the policy against the roles of the caller
+func permit(caller *Caller, subject string) error {
+	if policy == nil {
+		return nil
+	}
+
+	if caller == nil {
+		return ErrUnauthorized
+	}
+
+	if !policy.allows(caller.Roles, subject) {
+		log.Printf("Caller %d with roles %v is not allowed on %s", caller.UserID, caller.Roles, subject)
+		return &Error{Code: "403", Message: "Caller is not allowed on " + subject, Field: "caller.roles"}
+	}
+
+	return nil
+}
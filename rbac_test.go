@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRBAC(t *testing.T) {
+	Convey("Scenario: evaluating the default policy", t, func() {
+		So(DefaultPolicy.allows([]string{"reader"}, "datacenter.get"), ShouldBeTrue)
+		So(DefaultPolicy.allows([]string{"reader"}, "datacenter.set"), ShouldBeFalse)
+		So(DefaultPolicy.allows([]string{"reader", "editor"}, "datacenter.set"), ShouldBeTrue)
+		So(DefaultPolicy.allows([]string{"editor"}, "datacenter.purge"), ShouldBeFalse)
+		So(DefaultPolicy.allows([]string{"admin"}, "datacenter.purge"), ShouldBeTrue)
+		So(DefaultPolicy.allows(nil, "datacenter.get"), ShouldBeFalse)
+	})
+
+	Convey("Scenario: loading a policy from a file", t, func() {
+		f, err := ioutil.TempFile("", "policy")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+
+		_, err = f.WriteString(`{"roles":{"auditor":["datacenter.audit.*"]}}`)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		p, err := loadPolicy(f.Name())
+		So(err, ShouldBeNil)
+		So(p.allows([]string{"auditor"}, "datacenter.audit.find"), ShouldBeTrue)
+		So(p.allows([]string{"auditor"}, "datacenter.find"), ShouldBeFalse)
+	})
+
+	Convey("Scenario: authorizing requests", t, func() {
+		defer func(p *Policy) { policy = p }(policy)
+
+		called := false
+		h := authorize(func(ctx context.Context, msg *nats.Msg) { called = true })
+
+		Convey("Given no policy is set", func() {
+			policy = nil
+			h(context.Background(), &nats.Msg{Subject: "datacenter.del", Data: []byte(`{"id":1}`)})
+			So(called, ShouldBeTrue)
+		})
+
+		Convey("Given the caller has an allowed role", func() {
+			policy = &DefaultPolicy
+			h(context.Background(), &nats.Msg{Subject: "datacenter.del", Data: []byte(`{"id":1,"caller":{"user_id":1,"roles":["editor"],"service":"api","token":"secret"}}`)})
+			So(called, ShouldBeTrue)
+		})
+
+		Convey("Given the caller has no allowed role", func() {
+			policy = &DefaultPolicy
+			h(context.Background(), &nats.Msg{Subject: "datacenter.del", Data: []byte(`{"id":1,"caller":{"user_id":1,"roles":["reader"],"service":"api","token":"secret"}}`)})
+			So(called, ShouldBeFalse)
+		})
+
+		Convey("Given the caller claims a role its service can't grant", func() {
+			policy = &DefaultPolicy
+			callerServices["viewer"] = CallerService{Token: secretDigest, Roles: []string{"reader"}}
+			defer delete(callerServices, "viewer")
+
+			h(context.Background(), &nats.Msg{Subject: "datacenter.del", Data: []byte(`{"id":1,"caller":{"user_id":1,"roles":["admin"],"service":"viewer","token":"secret"}}`)})
+			So(called, ShouldBeFalse)
+		})
+
+		Convey("Given the request has no caller", func() {
+			policy = &DefaultPolicy
+			h(context.Background(), &nats.Msg{Subject: "datacenter.get", Data: []byte(`{"id":1}`)})
+			So(called, ShouldBeFalse)
+		})
+	})
+}
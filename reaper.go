@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// reapInterval : how often deleted datacenters are checked for purging
+var reapInterval = time.Hour
+
+// reap : permanently deletes the datacenters deleted before the given
+// retention period, one at a time through the same path as
+// datacenter.purge so each of them gets a purge audit entry and a
+// datacenter.deleted event. Datacenters restored in the meantime are left
+// untouched
+func reap(retention time.Duration) (int64, error) {
+	var expired []Entity
+	if err := db.Unscoped().Where("deleted_at < ?", time.Now().Add(-retention)).Order("id").Find(&expired).Error; err != nil {
+		return 0, dbError(err)
+	}
+
+	var purged int64
+	for i := range expired {
+		e := &expired[i]
+		e.Actor = systemActor
+		e.subject = "retention"
+		e.Expected = e.Version
+
+		err := e.Purge()
+		if err == ErrVersionConflict {
+			continue
+		}
+		if err != nil {
+			log.Printf("could not purge deleted datacenter %d: %s", e.ID, err.Error())
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// startReaper : periodically purges deleted datacenters once the
+// retention period set on ERNEST_DELETED_RETENTION (i.e. 720h) is over,
+// deleted datacenters are kept forever if it's not set
+func startReaper() {
+	r := os.Getenv("ERNEST_DELETED_RETENTION")
+	if r == "" {
+		return
+	}
+
+	retention, err := time.ParseDuration(r)
+	if err != nil {
+		log.Fatal("invalid deleted datacenters retention: " + err.Error())
+	}
+
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopping:
+				return
+			}
+
+			purged, err := reap(retention)
+			if err != nil {
+				log.Println("could not purge deleted datacenters: " + err.Error())
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d deleted datacenters", purged)
+			}
+		}
+	}()
+}
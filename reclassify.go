@@ -0,0 +1,217 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// classificationLock : advisory lock held while migrating the stored
+// classifications, so replicas starting at once migrate them only once
+const classificationLock = 7405211
+
+// systemActor : actor of the mutations made by the store itself
+const systemActor = "datacenter-store"
+
+// AppliedClassification : plaintext credential keys the datacenters of a
+// type are stored with
+type AppliedClassification struct {
+	Type      string `gorm:"primary_key"`
+	Plaintext string `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+// TableName : set AppliedClassification's table name to be
+// datacenter_classifications
+func (AppliedClassification) TableName() string {
+	return "datacenter_classifications"
+}
+
+// ReclassifyFailure : datacenter whose credentials could not be converted
+// to the classification of its type, it's left untouched
+type ReclassifyFailure struct {
+	ID           uint `gorm:"primary_key"`
+	DatacenterID uint `gorm:"index"`
+	Type         string
+	Error        string
+	CreatedAt    time.Time
+}
+
+// TableName : set ReclassifyFailure's table name to be
+// datacenter_reclassify_failures
+func (ReclassifyFailure) TableName() string {
+	return "datacenter_reclassify_failures"
+}
+
+// migrateClassification : converts the credentials stored with another
+// classification than the current one for their type, encrypting the keys
+// no longer stored in plaintext and decrypting the new plaintext ones.
+// Datacenters without an applied classification were stored with the
+// default one. Datacenters that can't be converted are recorded as
+// failures and skipped, so they don't block the startup
+func migrateClassification() error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := migrateClassificationOn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func migrateClassificationOn(tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", classificationLock).Error; err != nil {
+		return err
+	}
+
+	var applied []AppliedClassification
+	if err := tx.Find(&applied).Error; err != nil {
+		return err
+	}
+
+	stored := make(map[string][]string, len(applied))
+	for _, a := range applied {
+		stored[a.Type] = splitKeys(a.Plaintext)
+	}
+
+	var types []string
+	if err := tx.Unscoped().Model(&Entity{}).Pluck("DISTINCT type", &types).Error; err != nil {
+		return err
+	}
+
+	for _, t := range append(types, knownTypes()...) {
+		from, ok := stored[t]
+		if !ok {
+			from = defaultPlaintext
+		}
+		to := plaintextKeys(t)
+
+		if joinKeys(from) != joinKeys(to) {
+			count, failed, err := reclassifyStored(tx, t, from)
+			if err != nil {
+				return err
+			}
+			log.Printf("reclassified the credentials of %d %s datacenters, failed %d", count, t, failed)
+		}
+
+		err := tx.Exec("INSERT INTO datacenter_classifications (type, plaintext, updated_at) VALUES (?, ?, now()) ON CONFLICT (type) DO UPDATE SET plaintext = EXCLUDED.plaintext, updated_at = EXCLUDED.updated_at", t, joinKeys(to)).Error
+		if err != nil {
+			return err
+		}
+		stored[t] = to
+	}
+
+	return nil
+}
+
+// reclassifyStored : converts the credentials of every datacenter of the
+// given type, stored with the given plaintext keys, to the current
+// classification of the type. Each converted datacenter gets a new
+// version and an audit entry, the ones that can't be converted are
+// recorded on the datacenter_reclassify_failures table
+func reclassifyStored(tx *gorm.DB, t string, from []string) (int, int, error) {
+	var entities []Entity
+	if err := tx.Unscoped().Set("gorm:query_option", "FOR UPDATE").Where("type = ?", t).Order("id").Find(&entities).Error; err != nil {
+		return 0, 0, err
+	}
+
+	count, failed := 0, 0
+	for i := range entities {
+		e := &entities[i]
+
+		changes, err := reclassifyEntity(e, from)
+		if err != nil {
+			log.Println(err.Error())
+			if err := tx.Create(&ReclassifyFailure{DatacenterID: e.ID, Type: t, Error: err.Error()}).Error; err != nil {
+				return count, failed, err
+			}
+			failed++
+			continue
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		err = tx.Unscoped().Model(e).UpdateColumns(map[string]interface{}{
+			"credentials": e.Credentials,
+			"version":     gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return count, failed, err
+		}
+
+		e.Actor = systemActor
+		e.subject = "migration"
+		if err := e.auditChanges(tx, "reclassify", changes); err != nil {
+			return count, failed, err
+		}
+
+		count++
+	}
+
+	return count, failed, nil
+}
+
+// reclassifyEntity : converts the credentials of the datacenter, stored
+// with the given plaintext keys, returning the changed keys. Nothing is
+// changed if any of them can't be converted
+func reclassifyEntity(e *Entity, from []string) (Map, error) {
+	t := e.Type
+	changes := make(Map)
+	converted := make(Map, len(e.Credentials))
+
+	for k, v := range e.Credentials {
+		converted[k] = v
+
+		s, ok := v.(string)
+		wasEncrypted := !contains(from, k)
+		if !ok || s == "" || wasEncrypted == isEncrypted(t, k) {
+			continue
+		}
+
+		var x string
+		var err error
+		if wasEncrypted {
+			x, err = decrypt(s)
+			changes["credentials."+k] = "decrypted"
+		} else {
+			x, err = crypt(s)
+			changes["credentials."+k] = "encrypted"
+		}
+		if err != nil {
+			return nil, errors.New("could not reclassify credentials." + k + " of datacenter " + e.Name + ": " + err.Error())
+		}
+
+		converted[k] = x
+	}
+
+	e.Credentials = converted
+
+	return changes, nil
+}
+
+func joinKeys(keys []string) string {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func splitKeys(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ernestio/datacenter-store/secrets"
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReclassify(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_reclassify")
+	setupPg("test_reclassify")
+
+	Convey("Scenario: changing the classification of a datacenter type", t, func() {
+		setupTestSuite()
+		db.Exec("DELETE FROM datacenter_classifications")
+		db.Exec("DELETE FROM datacenter_audit")
+		defer delete(plaintext, "vcloud")
+
+		network, err := crypt("ext-100")
+		So(err, ShouldBeNil)
+
+		e := Entity{Name: "reclassified", Type: "vcloud", Credentials: Map{
+			"vcloud_url":       "http://vcloud.com",
+			"username":         "john",
+			"external_network": network,
+		}}
+		So(db.Create(&e).Error, ShouldBeNil)
+
+		plaintext["vcloud"] = []string{"vcloud_url", "external_network"}
+		So(migrateClassification(), ShouldBeNil)
+
+		stored := Entity{}
+		So(db.First(&stored, e.ID).Error, ShouldBeNil)
+
+		Convey("Then the new plaintext keys are decrypted", func() {
+			So(stored.Credentials["external_network"], ShouldEqual, "ext-100")
+			So(stored.Credentials["vcloud_url"], ShouldEqual, "http://vcloud.com")
+		})
+
+		Convey("Then the keys no longer in plaintext are encrypted", func() {
+			So(strings.HasPrefix(stored.Credentials["username"].(string), secrets.Prefix), ShouldBeTrue)
+			plain, err := decrypt(stored.Credentials["username"].(string))
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "john")
+		})
+
+		Convey("Then the datacenter gets a new version and an audit entry", func() {
+			So(stored.Version, ShouldEqual, e.Version+1)
+
+			var entries []AuditEntry
+			db.Where("datacenter_id = ?", e.ID).Find(&entries)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Operation, ShouldEqual, "reclassify")
+			So(entries[0].Actor, ShouldEqual, systemActor)
+			So(entries[0].Changes["credentials.username"], ShouldEqual, "encrypted")
+			So(entries[0].Changes["credentials.external_network"], ShouldEqual, "decrypted")
+		})
+
+		Convey("Then migrating again leaves the datacenter untouched", func() {
+			So(migrateClassification(), ShouldBeNil)
+
+			again := Entity{}
+			So(db.First(&again, e.ID).Error, ShouldBeNil)
+			So(again.Version, ShouldEqual, stored.Version)
+			So(again.Credentials["username"], ShouldEqual, stored.Credentials["username"])
+		})
+	})
+	Convey("Scenario: reclassifying a datacenter that can't be converted", t, func() {
+		setupTestSuite()
+		db.Exec("DELETE FROM datacenter_classifications")
+		db.Exec("DELETE FROM datacenter_reclassify_failures")
+		defer delete(plaintext, "vcloud")
+
+		network, err := crypt("ext-100")
+		So(err, ShouldBeNil)
+
+		broken := Entity{Name: "broken", Type: "vcloud", Credentials: Map{"external_network": secrets.Prefix + "1:invalid"}}
+		So(db.Create(&broken).Error, ShouldBeNil)
+		valid := Entity{Name: "valid", Type: "vcloud", Credentials: Map{"external_network": network}}
+		So(db.Create(&valid).Error, ShouldBeNil)
+
+		plaintext["vcloud"] = []string{"vcloud_url", "external_network"}
+		So(migrateClassification(), ShouldBeNil)
+
+		Convey("Then the failure is recorded and the datacenter left untouched", func() {
+			var failures []ReclassifyFailure
+			db.Find(&failures)
+			So(len(failures), ShouldEqual, 1)
+			So(failures[0].DatacenterID, ShouldEqual, broken.ID)
+			So(failures[0].Error, ShouldContainSubstring, "credentials.external_network")
+
+			stored := Entity{}
+			So(db.First(&stored, broken.ID).Error, ShouldBeNil)
+			So(stored.Version, ShouldEqual, broken.Version)
+			So(stored.Credentials["external_network"], ShouldEqual, secrets.Prefix+"1:invalid")
+		})
+
+		Convey("Then the other datacenters are converted", func() {
+			stored := Entity{}
+			So(db.First(&stored, valid.ID).Error, ShouldBeNil)
+			So(stored.Credentials["external_network"], ShouldEqual, "ext-100")
+		})
+	})
+}
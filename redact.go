@@ -0,0 +1,91 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// mask : hides every character of a secret but the last four, secrets
+// too short to safely show any of them are fully hidden
+func mask(s string) string {
+	if len(s) < 8 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
+
+// redacted : returns a copy of the entity with its encrypted credentials
+// masked and flagged, so ciphertext is never returned
+func (e Entity) redacted() Entity {
+	return e.redact(true)
+}
+
+// redact : masks and flags the encrypted credentials of a copy of the
+// entity, secrets are only decrypted to show their last four characters
+// when reveal is set, otherwise they are fully hidden
+func (e Entity) redact(reveal bool) Entity {
+	c := make(Map, len(e.Credentials))
+	encrypted := []string{}
+
+	for k, v := range e.Credentials {
+		xc, ok := v.(string)
+		if !ok || !isEncrypted(e.Type, k) {
+			c[k] = v
+			continue
+		}
+
+		plain := ""
+		if reveal {
+			plain, _ = decrypt(xc)
+		}
+
+		c[k] = mask(plain)
+		encrypted = append(encrypted, k)
+	}
+
+	sort.Strings(encrypted)
+
+	e.Credentials = c
+	e.Encrypted = encrypted
+
+	return e
+}
+
+// project : keeps only the given json fields of every entity on the list
+func project(list []interface{}, fields []string) ([]interface{}, error) {
+	for _, f := range fields {
+		if !contains(projectable, f) {
+			return nil, &Error{Code: "400", Message: "Invalid field", Field: f}
+		}
+	}
+
+	projected := make([]interface{}, len(list))
+	for i, e := range list {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+
+		var m map[string]interface{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+
+		p := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if v, ok := m[f]; ok {
+				p[f] = v
+			}
+		}
+		projected[i] = p
+	}
+
+	return projected, nil
+}
+
+// projectable : fields that can be requested on a projection
+var projectable = []string{"id", "name", "type", "owner_id", "group_id", "credentials", "encrypted_fields", "version", "CreatedAt", "UpdatedAt", "deleted_at"}
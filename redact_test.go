@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedact(t *testing.T) {
+	Convey("Scenario: masking secrets", t, func() {
+		So(mask("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"), ShouldEqual, "****EKEY")
+		So(mask("short"), ShouldEqual, "****")
+		So(mask(""), ShouldEqual, "****")
+	})
+
+	Convey("Scenario: redacting a datacenter", t, func() {
+		defer func(p KeyProvider) { keyProvider = p }(currentKeyProvider())
+		keyProvider, _ = NewKeyring("1", oldTestKey, nil)
+
+		x, err := crypt("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY")
+		So(err, ShouldBeNil)
+
+		e := Entity{ID: 1, Name: "test", Type: "aws", Credentials: Map{"region": "eu-west-1", "secret_access_key": x}}
+		r := e.redacted()
+
+		So(r.Credentials["region"], ShouldEqual, "eu-west-1")
+		So(r.Credentials["secret_access_key"], ShouldEqual, "****EKEY")
+		So(r.Encrypted, ShouldResemble, []string{"secret_access_key"})
+		So(e.Credentials["secret_access_key"], ShouldEqual, x)
+
+		Convey("Given only some fields are requested", func() {
+			list, err := project([]interface{}{r}, []string{"id", "name"})
+			So(err, ShouldBeNil)
+			So(list[0], ShouldResemble, map[string]interface{}{"id": float64(1), "name": "test"})
+		})
+
+		Convey("Given the version and deletion time are requested", func() {
+			_, err := project([]interface{}{r}, []string{"name", "version", "deleted_at"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Given the secrets are not revealed", func() {
+			h := e.redact(false)
+			So(h.Credentials["secret_access_key"], ShouldEqual, "****")
+			So(h.Encrypted, ShouldResemble, []string{"secret_access_key"})
+		})
+
+		Convey("Given an unknown field is requested", func() {
+			_, err := project([]interface{}{r}, []string{"password"})
+			So(err, ShouldNotBeNil)
+			So(toError(err).Field, ShouldEqual, "password")
+		})
+	})
+}
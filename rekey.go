@@ -0,0 +1,226 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nats-io/go-nats"
+)
+
+// defaultRekeyBatchSize : number of datacenters re-encrypted per batch
+const defaultRekeyBatchSize = 100
+
+// RekeyResult : summary of a rekey operation. When the request timeout
+// is reached before every datacenter is processed, done is false and the
+// operation can be resumed after next_id
+type RekeyResult struct {
+	KeyID   string         `json:"key_id"`
+	Rekeyed int            `json:"rekeyed"`
+	Skipped int            `json:"skipped"`
+	Failed  []RekeyFailure `json:"failed"`
+	NextID  uint           `json:"next_id,omitempty"`
+	Done    bool           `json:"done"`
+}
+
+// RekeyFailure : datacenter whose credentials could not be re-encrypted,
+// it's left untouched
+type RekeyFailure struct {
+	DatacenterID uint   `json:"datacenter_id"`
+	Error        string `json:"error"`
+}
+
+// rekey : re-encrypts the credentials of every datacenter after the
+// given id under the primary key, processing them in committed batches
+// until the request times out
+func rekey(ctx context.Context, msg *nats.Msg) {
+	var input struct {
+		BatchSize int  `json:"batch_size"`
+		AfterID   uint `json:"after_id"`
+	}
+
+	body := msg.Data
+	if len(body) == 0 {
+		body = []byte(`{}`)
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		fail(msg, ErrInvalidInput)
+		return
+	}
+
+	// rekeying touches the datacenters of every tenant, so it's only
+	// allowed to admin callers
+	caller, err := callerOf(body)
+	if err == nil && caller == nil {
+		err = ErrUnauthorized
+	}
+	if err == nil && !caller.isAdmin() {
+		err = ErrForbidden
+	}
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	if input.BatchSize < 1 {
+		input.BatchSize = defaultRekeyBatchSize
+	}
+
+	r := rekeyer{ctx: ctx, batch: input.BatchSize, actor: caller.actor(), subject: msg.Subject}
+
+	res, err := r.run(input.AfterID)
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+
+	respond(msg, res)
+}
+
+// rekeyer : re-encrypts the datacenter credentials in batches
+type rekeyer struct {
+	ctx     context.Context
+	batch   int
+	actor   string
+	subject string
+	primary string
+}
+
+func (r *rekeyer) run(last uint) (RekeyResult, error) {
+	primary, err := currentKeyProvider().PrimaryID()
+	if err != nil {
+		log.Println("Could not get primary key " + err.Error())
+		return RekeyResult{}, ErrEncryption
+	}
+	r.primary = primary
+
+	res := RekeyResult{KeyID: primary, Failed: []RekeyFailure{}}
+
+	var took time.Duration
+	for {
+		// batches are only started if they are expected to finish before
+		// the request times out
+		if d, ok := deadline(r.ctx); ok && time.Until(d) < took*2 {
+			res.NextID = last
+			return res, nil
+		}
+
+		start := time.Now()
+		next, err := r.rekeyBatch(last, &res)
+		if err == ErrTimeout {
+			res.NextID = last
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		took = time.Since(start)
+
+		if next == 0 {
+			res.Done = true
+			return res, nil
+		}
+
+		last = next
+		log.Printf("rekeyed %d datacenters, skipped %d, failed %d", res.Rekeyed, res.Skipped, len(res.Failed))
+	}
+}
+
+// rekeyBatch : re-encrypts the datacenters of the batch following the
+// given id on a single transaction, rows are locked so concurrent writes
+// wait for it to be committed. It returns the id of the last datacenter
+// of the batch, or 0 once every datacenter was processed
+func (r *rekeyer) rekeyBatch(after uint, res *RekeyResult) (uint, error) {
+	var last uint
+	batch := RekeyResult{}
+
+	err := transaction(r.ctx, func(tx *gorm.DB) error {
+		var entities []Entity
+		if err := tx.Unscoped().Set("gorm:query_option", "FOR UPDATE").Where("id > ?", after).Order("id").Limit(r.batch).Find(&entities).Error; err != nil {
+			return dbError(err)
+		}
+
+		for i := range entities {
+			e := &entities[i]
+			last = e.ID
+
+			changes, err := r.rekeyEntity(e)
+			if err != nil {
+				batch.Failed = append(batch.Failed, RekeyFailure{DatacenterID: e.ID, Error: err.Error()})
+				continue
+			}
+			if len(changes) == 0 {
+				batch.Skipped++
+				continue
+			}
+
+			err = tx.Unscoped().Model(e).UpdateColumns(map[string]interface{}{
+				"credentials": e.Credentials,
+				"version":     gorm.Expr("version + 1"),
+			}).Error
+			if err != nil {
+				return dbError(err)
+			}
+
+			e.Actor = r.actor
+			e.subject = r.subject
+			if err := e.auditChanges(tx, "rekey", changes); err != nil {
+				return err
+			}
+			batch.Rekeyed++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res.Rekeyed += batch.Rekeyed
+	res.Skipped += batch.Skipped
+	res.Failed = append(res.Failed, batch.Failed...)
+
+	return last, nil
+}
+
+// rekeyEntity : re-encrypts the credentials of the datacenter not
+// encrypted with the primary key, returning the changed keys. Nothing is
+// changed if any of them can't be re-encrypted
+func (r *rekeyer) rekeyEntity(e *Entity) (Map, error) {
+	changes := make(Map)
+	rekeyed := make(Map, len(e.Credentials))
+
+	for k, v := range e.Credentials {
+		rekeyed[k] = v
+
+		s, ok := v.(string)
+		if !ok || s == "" || !isEncrypted(e.Type, k) || !needsRekey(s, r.primary) {
+			continue
+		}
+
+		plain, err := decrypt(s)
+		if err != nil {
+			log.Printf("Could not decrypt credentials.%s of datacenter %d: %s", k, e.ID, err.Error())
+			return nil, &Error{Code: ErrDecryption.Code, Message: ErrDecryption.Message, Field: "credentials." + k}
+		}
+
+		x, err := crypt(plain)
+		if err != nil {
+			log.Printf("Could not encrypt credentials.%s of datacenter %d: %s", k, e.ID, err.Error())
+			return nil, &Error{Code: ErrEncryption.Code, Message: ErrEncryption.Message, Field: "credentials." + k}
+		}
+
+		rekeyed[k] = x
+		changes["credentials."+k] = "rekeyed"
+	}
+
+	e.Credentials = rekeyed
+
+	return changes, nil
+}
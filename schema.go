@@ -0,0 +1,158 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/go-nats"
+)
+
+// requestSchema : json schema of the v2 request envelope
+const requestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://ernest.io/schemas/datacenter/v2/request.json",
+  "title": "Datacenter store request",
+  "type": "object",
+  "required": ["api_version"],
+  "properties": {
+    "api_version": {"type": "string", "const": "2"},
+    "request_id": {"type": "string"},
+    "data": {
+      "type": "object",
+      "description": "v1 request body for the requested subject",
+      "properties": {
+        "id": {"type": "integer", "minimum": 0},
+        "name": {"type": "string"},
+        "type": {"type": "string"},
+        "owner_id": {"type": "integer", "minimum": 0},
+        "group_id": {"type": "integer", "minimum": 0},
+        "credentials": {"type": "object"},
+        "expected_version": {"type": "integer", "minimum": 0},
+        "ids": {"type": "array", "items": {"type": "string"}},
+        "names": {"type": "array", "items": {"type": "string"}},
+        "types": {"type": "array", "items": {"type": "string"}},
+        "name_prefix": {"type": "string"},
+        "name_contains": {"type": "string"},
+        "name_glob": {"type": "string"},
+        "created_after": {"type": "string", "format": "date-time"},
+        "created_before": {"type": "string", "format": "date-time"},
+        "updated_after": {"type": "string", "format": "date-time"},
+        "updated_before": {"type": "string", "format": "date-time"},
+        "include_deleted": {"type": "boolean"},
+        "limit": {"type": "integer", "minimum": 0},
+        "offset": {"type": "integer", "minimum": 0},
+        "after": {"type": "string"},
+        "order_by": {"type": "string"},
+        "fields": {"type": "array", "items": {"type": "string"}},
+        "redact": {"type": "boolean"},
+        "caller": {
+          "type": "object",
+          "properties": {
+            "user_id": {"type": "integer", "minimum": 0},
+            "group_ids": {"type": "array", "items": {"type": "integer", "minimum": 0}},
+            "roles": {"type": "array", "items": {"type": "string"}},
+            "service": {"type": "string"},
+            "token": {"type": "string"}
+          }
+        }
+      }
+    }
+  }
+}`
+
+// responseSchema : json schema of the v2 response envelope
+const responseSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://ernest.io/schemas/datacenter/v2/response.json",
+  "title": "Datacenter store response",
+  "type": "object",
+  "required": ["api_version"],
+  "oneOf": [
+    {"required": ["data"]},
+    {"required": ["error"]}
+  ],
+  "properties": {
+    "api_version": {"type": "string", "const": "2"},
+    "request_id": {"type": "string"},
+    "data": {
+      "anyOf": [
+        {"$ref": "#/definitions/datacenter"},
+        {"$ref": "#/definitions/page"},
+        {"type": "array", "items": {"$ref": "#/definitions/datacenter"}},
+        {"type": "object"}
+      ]
+    },
+    "error": {"$ref": "#/definitions/error"}
+  },
+  "definitions": {
+    "datacenter": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer"},
+        "name": {"type": "string"},
+        "type": {"type": "string"},
+        "owner_id": {"type": "integer"},
+        "group_id": {"type": "integer"},
+        "credentials": {"type": "object"},
+        "encrypted_fields": {"type": "array", "items": {"type": "string"}},
+        "version": {"type": "integer"},
+        "created_at": {"type": "string", "format": "date-time"},
+        "updated_at": {"type": "string", "format": "date-time"},
+        "deleted_at": {"type": "string", "format": "date-time"}
+      }
+    },
+    "page": {
+      "type": "object",
+      "required": ["items", "total", "limit"],
+      "properties": {
+        "items": {"type": "array", "items": {"$ref": "#/definitions/datacenter"}},
+        "total": {"type": "integer"},
+        "limit": {"type": "integer"},
+        "offset": {"type": "integer"},
+        "next": {"type": "string"}
+      }
+    },
+    "error": {
+      "type": "object",
+      "required": ["code", "message", "retryable"],
+      "properties": {
+        "code": {"type": "string"},
+        "message": {"type": "string"},
+        "field": {"type": "string"},
+        "retryable": {"type": "boolean"},
+        "fields": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["field", "message"],
+            "properties": {
+              "field": {"type": "string"},
+              "message": {"type": "string"}
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// Schemas : json schemas of the versioned envelopes
+type Schemas struct {
+	APIVersion string          `json:"api_version"`
+	Request    json.RawMessage `json:"request"`
+	Response   json.RawMessage `json:"response"`
+}
+
+// schema : replies with the json schemas of the request and response
+// envelopes
+func schema(ctx context.Context, msg *nats.Msg) {
+	respond(msg, Schemas{
+		APIVersion: APIVersion,
+		Request:    json.RawMessage(requestSchema),
+		Response:   json.RawMessage(responseSchema),
+	})
+}
@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package secrets encrypts and decrypts credential values on the format
+// the store keeps them, enc:<key id>:<encrypted data key>:<encrypted value>,
+// shared by the store and its clients.
+package secrets
+
+import (
+	"errors"
+	"strings"
+
+	aes "github.com/ernestio/crypto/aes"
+)
+
+// Prefix : prefix of values encrypted with a versioned key, values
+// without it were encrypted with the legacy key
+const Prefix = "enc:"
+
+// LegacyKeyID : id of the key used to encrypt values without version
+const LegacyKeyID = "1"
+
+// ErrInvalid : the encrypted value is not on the expected format
+var ErrInvalid = errors.New("invalid encrypted value")
+
+// Unwrapper : decrypts the data keys values are encrypted with
+type Unwrapper interface {
+	// Unwrap : decrypts a data key encrypted with the given key
+	Unwrap(id, wrapped string) (string, error)
+}
+
+// Versioned : determines if a value was encrypted with a versioned key
+func Versioned(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// KeyID : returns the id of the key a value was encrypted with
+func KeyID(s string) string {
+	if !Versioned(s) {
+		return LegacyKeyID
+	}
+	return strings.SplitN(strings.TrimPrefix(s, Prefix), ":", 2)[0]
+}
+
+// Encrypt : encrypts a value with the given data key, storing it
+// alongside the data key wrapped with the key of the given id
+func Encrypt(s, id, wrapped, dataKey string) (string, error) {
+	encrypted, err := aes.New().Encrypt(s, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + id + ":" + wrapped + ":" + encrypted, nil
+}
+
+// Decrypt : decrypts a value, unwrapping its data key with the given
+// unwrapper
+func Decrypt(s string, u Unwrapper) (string, error) {
+	// legacy values were encrypted directly with the key, the same way
+	// data keys are wrapped
+	if !Versioned(s) {
+		return u.Unwrap(LegacyKeyID, s)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, Prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", ErrInvalid
+	}
+
+	dataKey, err := u.Unwrap(parts[0], parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	return aes.New().Decrypt(parts[2], dataKey)
+}
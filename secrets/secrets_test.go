@@ -0,0 +1,66 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package secrets
+
+import (
+	"errors"
+	"testing"
+
+	aes "github.com/ernestio/crypto/aes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testKey = "mMYlPIvI11z20H1BnBmB223355667788"
+const testDataKey = "aAbBcCdDeEfFgGhHiIjJkKlLmMnNoOpP"
+
+// keys : unwraps data keys with the keys of the given ids
+type keys map[string]string
+
+func (k keys) Unwrap(id, wrapped string) (string, error) {
+	key, ok := k[id]
+	if !ok {
+		return "", errors.New("unknown key " + id)
+	}
+	return aes.New().Decrypt(wrapped, key)
+}
+
+func TestSecrets(t *testing.T) {
+	Convey("Scenario: encrypting and decrypting values", t, func() {
+		wrapped, err := aes.New().Encrypt(testDataKey, testKey)
+		So(err, ShouldBeNil)
+
+		s, err := Encrypt("secret", "2", wrapped, testDataKey)
+		So(err, ShouldBeNil)
+		So(Versioned(s), ShouldBeTrue)
+		So(KeyID(s), ShouldEqual, "2")
+
+		Convey("Given the key it was encrypted with", func() {
+			plain, err := Decrypt(s, keys{"2": testKey})
+			So(err, ShouldBeNil)
+			So(plain, ShouldEqual, "secret")
+		})
+
+		Convey("Given an unknown key", func() {
+			_, err := Decrypt(s, keys{"1": testKey})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Given a value on an invalid format", func() {
+			_, err := Decrypt(Prefix+"2:"+wrapped, keys{"2": testKey})
+			So(err, ShouldEqual, ErrInvalid)
+		})
+	})
+
+	Convey("Scenario: decrypting legacy values", t, func() {
+		s, err := aes.New().Encrypt("secret", testKey)
+		So(err, ShouldBeNil)
+		So(Versioned(s), ShouldBeFalse)
+		So(KeyID(s), ShouldEqual, LegacyKeyID)
+
+		plain, err := Decrypt(s, keys{LegacyKeyID: testKey})
+		So(err, ShouldBeNil)
+		So(plain, ShouldEqual, "secret")
+	})
+}
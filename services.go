@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/nats-io/go-nats"
+)
+
+// services : sha256 hex digest of the token of each service allowed to
+// get decrypted credentials
+var services = map[string]string{}
+
+// ServiceIdentity : identity presented by a service
+type ServiceIdentity struct {
+	Service string `json:"service"`
+	Token   string `json:"token"`
+}
+
+// loadServices : loads the services allowed to get decrypted credentials
+// from a json file with the format {"<service>":"<sha256 of token>"}
+func loadServices(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	s := make(map[string]string)
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	services = s
+
+	return nil
+}
+
+// allowed : determines if the identity matches one of the allowed services
+func (i ServiceIdentity) allowed() bool {
+	expected, ok := services[i.Service]
+	return ok && i.verifies(expected)
+}
+
+// verifies : determines if the token of the identity matches the given
+// sha256 hex digest
+func (i ServiceIdentity) verifies(expected string) bool {
+	if i.Token == "" {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(i.Token))
+
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(expected)) == 1
+}
+
+// getDecrypted : replies with the datacenter matching the given id or
+// name with its credentials decrypted, only to allowed services
+func getDecrypted(ctx context.Context, msg *nats.Msg) {
+	var i ServiceIdentity
+	if err := json.Unmarshal(msg.Data, &i); err != nil {
+		fail(msg, ErrInvalidInput)
+		return
+	}
+
+	if !i.allowed() {
+		log.Println("Service " + i.Service + " is not allowed to get decrypted credentials")
+		fail(msg, ErrForbidden)
+		return
+	}
+
+	// allowed services can get any datacenter, unless they request it on
+	// behalf of a caller
+	e := Entity{ctx: ctx, caller: &Caller{Service: i.Service, Roles: []string{adminRole}}}
+	if err := e.loadFromInput(msg.Data); err != nil {
+		fail(msg, err)
+		return
+	}
+
+	dc, err := decryptCredentials(e.Type, e.Credentials)
+	if err != nil {
+		fail(msg, err)
+		return
+	}
+	e.Credentials = dc
+
+	respond(msg, e)
+}
@@ -0,0 +1,60 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sha256 of "secret"
+const secretDigest = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+
+func TestDecryptedHandler(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_decrypted")
+	setupPg("test_decrypted")
+	startHandler()
+
+	services = map[string]string{"workflow-manager": secretDigest}
+	defer func() { services = map[string]string{} }()
+
+	Convey("Scenario: getting a datacenter with decrypted credentials", t, func() {
+		setupTestSuite()
+		e := Entity{Name: "decrypted", Type: "aws", Credentials: Map{"region": "eu-west-1", "access_key_id": "test-id", "secret_access_key": "test-key"}}
+		So(e.Save(), ShouldBeNil)
+
+		Convey("Given the service is allowed", func() {
+			msg, err := n.Request("datacenter.get.decrypted", []byte(`{"name":"decrypted","service":"workflow-manager","token":"secret"}`), time.Second)
+			So(err, ShouldBeNil)
+
+			output := Entity{}
+			So(json.Unmarshal(msg.Data, &output), ShouldBeNil)
+			So(output.Credentials["region"], ShouldEqual, "eu-west-1")
+			So(output.Credentials["access_key_id"], ShouldEqual, "test-id")
+			So(output.Credentials["secret_access_key"], ShouldEqual, "test-key")
+		})
+
+		Convey("Given the service presents an invalid token", func() {
+			msg, err := n.Request("datacenter.get.decrypted", []byte(`{"name":"decrypted","service":"workflow-manager","token":"guess"}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrForbidden.Encoded()))
+		})
+
+		Convey("Given the service is not allowed", func() {
+			msg, err := n.Request("datacenter.get.decrypted", []byte(`{"name":"decrypted","service":"monit","token":"secret"}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrForbidden.Encoded()))
+		})
+	})
+}
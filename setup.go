@@ -5,28 +5,258 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	ecc "github.com/ernestio/ernest-config-client"
+	"github.com/jinzhu/gorm"
 )
 
 var c *ecc.Config
 
+// natsEnabled : determines if the store is served through nats, it can
+// be disabled with ERNEST_DISABLE_NATS when only the http api is needed
+func natsEnabled() bool {
+	return os.Getenv("ERNEST_DISABLE_NATS") != "true"
+}
+
 func setupNats() {
 	c = ecc.NewConfig(os.Getenv("NATS_URI"))
 	n = c.Nats()
 }
 
+func setupQueueGroup() {
+	if group := os.Getenv("ERNEST_QUEUE_GROUP"); group != "" {
+		queueGroup = group
+	}
+}
+
+func setupRequestTimeout() {
+	t := os.Getenv("ERNEST_REQUEST_TIMEOUT")
+	if t == "" {
+		return
+	}
+	d, err := time.ParseDuration(t)
+	if err != nil || d <= 0 {
+		log.Fatal("invalid request timeout " + t)
+	}
+	requestTimeout = d
+}
+
+func setupClassification() {
+	path := os.Getenv("ERNEST_CREDENTIALS_CONFIG")
+	if path == "" {
+		return
+	}
+	if err := loadClassification(path); err != nil {
+		log.Fatal("could not load credentials classification: " + err.Error())
+	}
+}
+
+func setupTenancy() {
+	requireCaller = os.Getenv("ERNEST_REQUIRE_CALLER") == "true"
+
+	unownedOwner = envID("ERNEST_UNOWNED_OWNER_ID")
+	unownedGroup = envID("ERNEST_UNOWNED_GROUP_ID")
+
+	path := os.Getenv("ERNEST_CALLER_SERVICES_FILE")
+	if path == "" {
+		return
+	}
+
+	if err := loadCallerServices(path); err != nil {
+		log.Fatal("could not load caller services: " + err.Error())
+	}
+}
+
+// envID : parses the id set on the given environment variable, 0 if it's
+// not set
+func envID(name string) uint {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		log.Fatal("invalid " + name + ": " + err.Error())
+	}
+
+	return uint(id)
+}
+
+func setupPolicy() {
+	path := os.Getenv("ERNEST_RBAC_POLICY_FILE")
+	if path == "" {
+		if os.Getenv("ERNEST_RBAC_ENABLED") == "true" {
+			policy = &DefaultPolicy
+		}
+		return
+	}
+
+	p, err := loadPolicy(path)
+	if err != nil {
+		log.Fatal("could not load rbac policy: " + err.Error())
+	}
+	policy = p
+}
+
+func setupServices() {
+	path := os.Getenv("ERNEST_DECRYPT_SERVICES_FILE")
+	if path == "" {
+		return
+	}
+	if err := loadServices(path); err != nil {
+		log.Fatal("could not load allowed services: " + err.Error())
+	}
+}
+
+func setupKeyProvider() {
+	var p KeyProvider
+	var err error
+
+	switch os.Getenv("ERNEST_CRYPTO_PROVIDER") {
+	case "", "env":
+		p, err = NewEnvKeyProvider()
+	case "file":
+		var fp *FileKeyProvider
+		if fp, err = NewFileKeyProvider(os.Getenv("ERNEST_CRYPTO_KEY_FILE")); err == nil {
+			fp.Watch(time.Second * 10)
+			p = fp
+		}
+	case "vault":
+		key := os.Getenv("ERNEST_CRYPTO_VAULT_KEY")
+		if key == "" {
+			key = "datacenter-store"
+		}
+		p = NewVaultKeyProvider(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), key)
+		p, err = withFallbackKeys(p)
+	default:
+		log.Fatal("unknown crypto provider " + os.Getenv("ERNEST_CRYPTO_PROVIDER"))
+	}
+
+	if err == nil {
+		err = checkKeyProvider(p)
+	}
+	if err != nil {
+		log.Fatal("no usable encryption key: " + err.Error())
+	}
+
+	keyProvider = p
+}
+
+// withFallbackKeys : keeps the keys configured through the env or file
+// providers to read values encrypted before switching to the given one
+func withFallbackKeys(p KeyProvider) (KeyProvider, error) {
+	var fallback KeyProvider
+	var err error
+
+	switch {
+	case os.Getenv("ERNEST_CRYPTO_KEY_FILE") != "":
+		var fp *FileKeyProvider
+		if fp, err = NewFileKeyProvider(os.Getenv("ERNEST_CRYPTO_KEY_FILE")); err == nil {
+			fp.Watch(time.Second * 10)
+			fallback = fp
+		}
+	case os.Getenv("ERNEST_CRYPTO_KEY") != "":
+		fallback, err = NewEnvKeyProvider()
+	default:
+		return p, nil
+	}
+
+	if err == nil {
+		err = checkKeyProvider(fallback)
+	}
+	if err != nil {
+		return nil, errors.New("invalid fallback keys: " + err.Error())
+	}
+
+	return &FallbackKeyProvider{Primary: p, Fallback: fallback}, nil
+}
+
 func setupPg(dbname string) {
-	db = c.Postgres(dbname)
+	if url := os.Getenv("ERNEST_POSTGRES_URL"); url != "" {
+		setDB(openPg(url, dbname))
+	} else if c != nil {
+		setDB(c.Postgres(dbname))
+	} else {
+		log.Fatal("ERNEST_POSTGRES_URL is required when ERNEST_DISABLE_NATS is set")
+	}
+
 	for true {
-		if err = db.AutoMigrate(&Entity{}).Error; err != nil {
-			log.Println("could not connect run migrations. retrying")
+		err = db.AutoMigrate(&Entity{}, &AuditEntry{}, &Event{}, &AppliedClassification{}, &ReclassifyFailure{}).Error
+		if err == nil {
+			err = migrateNameIndex()
+		}
+		if err == nil {
+			err = migrateClassification()
+		}
+		if err == nil {
+			err = migrateOwnership()
+		}
+		if err != nil {
+			setMigrations(healthDown, err)
+			log.Println("could not run migrations: " + err.Error() + ". retrying")
 			time.Sleep(time.Second * 10)
 			continue
 		}
+		setMigrations(healthOK, nil)
 		return
 	}
 }
+
+// migrateNameIndex : replaces the unique index on every datacenter name,
+// created by previous versions, by one on the datacenters not deleted, so
+// the name of a deleted datacenter can be reused
+func migrateNameIndex() error {
+	table := Entity{}.TableName()
+	if err := db.Exec("DROP INDEX IF EXISTS uix_" + table + "_name").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS uix_" + table + "_live_name ON " + table + " (name) WHERE deleted_at IS NULL").Error
+}
+
+// pgURL : sets the database of the given postgres url, ssl is disabled
+// unless the url sets its sslmode
+func pgURL(raw, dbname string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return "", errors.New("unsupported scheme " + u.Scheme)
+	}
+
+	u.Path = "/" + dbname
+
+	q := u.Query()
+	if q.Get("sslmode") == "" {
+		q.Set("sslmode", "disable")
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// openPg : connects to the database on the given postgres url, used
+// instead of the url provided by the config service
+func openPg(url, dbname string) *gorm.DB {
+	dsn, err := pgURL(url, dbname)
+	if err != nil {
+		log.Fatal("invalid ERNEST_POSTGRES_URL: " + err.Error())
+	}
+
+	for {
+		pg, err := gorm.Open("postgres", dsn)
+		if err == nil {
+			return pg
+		}
+		setMigrations(healthDown, err)
+		log.Println("could not connect to postgres. retrying")
+		time.Sleep(time.Second * 10)
+	}
+}
@@ -0,0 +1,207 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// defaultShutdownTimeout : time given to in flight requests to finish
+// when ERNEST_SHUTDOWN_TIMEOUT is not set
+const defaultShutdownTimeout = time.Second * 30
+
+// metricsShutdownTimeout : time given to in flight scrapes and probes to
+// finish once the requests are drained
+const metricsShutdownTimeout = time.Second * 5
+
+// ErrShuttingDown : the store is shutting down and not accepting requests
+var ErrShuttingDown = &Error{Code: "503", Message: "Store is shutting down", Retryable: true}
+
+// stopping : closed once the store starts shutting down, background
+// workers stop when it's closed
+var stopping = make(chan struct{})
+
+// subscriptions : nats subscriptions of every datacenter subject
+var subscriptions []*nats.Subscription
+
+// inflight : requests being handled, new requests are only tracked while
+// holding a read lock on inflightMu and before stopping is closed
+var inflight sync.WaitGroup
+var inflightMu sync.RWMutex
+
+// admit : tracks a new request until inflight.Done is called for it, it
+// returns false once the store is shutting down
+func admit() bool {
+	inflightMu.RLock()
+	defer inflightMu.RUnlock()
+
+	select {
+	case <-stopping:
+		return false
+	default:
+	}
+
+	inflight.Add(1)
+	return true
+}
+
+// waitForSignal : blocks until SIGTERM or SIGINT is received, then shuts
+// down the store returning the exit status
+func waitForSignal() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	s := <-signals
+	log.Println("Received " + s.String() + ", shutting down")
+
+	timeout := defaultShutdownTimeout
+	if t := os.Getenv("ERNEST_SHUTDOWN_TIMEOUT"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			log.Println("invalid shutdown timeout " + t + ", using the default one")
+		} else {
+			timeout = d
+		}
+	}
+
+	return shutdown(timeout)
+}
+
+// shutdown : stops accepting requests, waits for the in flight ones until
+// the timeout and closes every connection. It returns 0 if every request
+// finished and every connection was closed cleanly, 1 otherwise
+func shutdown(timeout time.Duration) int {
+	status := 0
+
+	if !drain(timeout) {
+		log.Println("Timed out waiting for in flight requests")
+		status = 1
+	}
+
+	if grpcSrv != nil {
+		grpcSrv.Stop()
+	}
+
+	if metricsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Println("could not shut down the metrics endpoint: " + err.Error())
+		}
+		cancel()
+	}
+
+	if probesServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		if err := probesServer.Shutdown(ctx); err != nil {
+			log.Println("could not shut down the probes endpoint: " + err.Error())
+		}
+		cancel()
+	}
+
+	if n != nil {
+		if err := publishOutbox(); err != nil {
+			log.Println("could not publish events: " + err.Error())
+		}
+		n.Close()
+	}
+
+	if db != nil {
+		if err := db.Close(); err != nil {
+			log.Println("could not close the database: " + err.Error())
+			status = 1
+		}
+	}
+
+	log.Println("Shut down")
+
+	return status
+}
+
+// drain : stops accepting requests on every api and waits for the in
+// flight ones until the timeout, it returns false if they did not finish
+func drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	// messages delivered to a subscription are dropped once it's
+	// unsubscribed, so the ones nats already sent are admitted before
+	// unsubscribing, and served along with the other in flight requests
+	if n != nil {
+		_ = n.Flush()
+	}
+	for _, s := range subscriptions {
+		waitPending(s, deadline)
+		if err := s.Unsubscribe(); err != nil {
+			log.Println("could not unsubscribe: " + err.Error())
+		}
+	}
+	if n != nil {
+		_ = n.Flush()
+	}
+
+	inflightMu.Lock()
+	close(stopping)
+	inflightMu.Unlock()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		inflight.Wait()
+	}()
+
+	if httpServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				log.Println("could not shut down the http api: " + err.Error())
+			}
+		}()
+	}
+
+	if grpcSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			grpcSrv.GracefulStop()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// waitPending : waits until every message delivered to the subscription
+// is passed to its handler, or the deadline expires
+func waitPending(s *nats.Subscription, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		msgs, _, err := s.Pending()
+		if err != nil || msgs == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,102 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("Scenario: draining in flight requests", t, func() {
+		subscriptions = nil
+		defer func() { stopping = make(chan struct{}) }()
+
+		var finished int32
+		slow := func(d time.Duration) nats.MsgHandler {
+			return newPool("test", func(ctx context.Context, msg *nats.Msg) {
+				time.Sleep(d)
+				atomic.StoreInt32(&finished, 1)
+			}, 1, 1).handle
+		}
+
+		Convey("Given the requests finish before the timeout", func() {
+			go slow(time.Millisecond * 100)(&nats.Msg{})
+			time.Sleep(time.Millisecond * 20)
+
+			So(drain(time.Second), ShouldBeTrue)
+			So(atomic.LoadInt32(&finished), ShouldEqual, 1)
+		})
+
+		Convey("Given the requests don't finish before the timeout", func() {
+			go slow(time.Millisecond * 300)(&nats.Msg{})
+			time.Sleep(time.Millisecond * 20)
+
+			So(drain(time.Millisecond*50), ShouldBeFalse)
+			So(atomic.LoadInt32(&finished), ShouldEqual, 0)
+			inflight.Wait()
+		})
+
+		Convey("Given requests were delivered but not handled yet", func() {
+			conn, err := nats.Connect(natsURI())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			var handled int32
+			sub, err := conn.Subscribe("datacenter.shutdown", func(msg *nats.Msg) {
+				atomic.AddInt32(&handled, 1)
+				time.Sleep(time.Millisecond * 20)
+			})
+			So(err, ShouldBeNil)
+			subscriptions = append(subscriptions, sub)
+
+			for i := 0; i < 5; i++ {
+				So(conn.Publish("datacenter.shutdown", []byte(`{}`)), ShouldBeNil)
+			}
+			So(conn.Flush(), ShouldBeNil)
+
+			So(drain(time.Second), ShouldBeTrue)
+			So(atomic.LoadInt32(&handled), ShouldEqual, 5)
+		})
+
+		Convey("Given requests were delivered to a worker pool but not admitted yet", func() {
+			conn, err := nats.Connect(natsURI())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			var served int32
+			handle := newPool("test", func(ctx context.Context, msg *nats.Msg) {
+				atomic.AddInt32(&served, 1)
+			}, 1, 8).handle
+
+			sub, err := conn.Subscribe("datacenter.shutdown", func(msg *nats.Msg) {
+				handle(msg)
+				time.Sleep(time.Millisecond * 20)
+			})
+			So(err, ShouldBeNil)
+			subscriptions = append(subscriptions, sub)
+
+			for i := 0; i < 5; i++ {
+				So(conn.Publish("datacenter.shutdown", []byte(`{}`)), ShouldBeNil)
+			}
+			So(conn.Flush(), ShouldBeNil)
+
+			So(drain(time.Second), ShouldBeTrue)
+			So(atomic.LoadInt32(&served), ShouldEqual, 5)
+		})
+
+		Convey("Given a request is received once the store is shutting down", func() {
+			So(drain(time.Second), ShouldBeTrue)
+
+			_, e := call(context.Background(), "datacenter.get", map[string]interface{}{"id": 1})
+			So(e, ShouldEqual, ErrShuttingDown)
+		})
+	})
+}
@@ -12,6 +12,12 @@ import (
 	"github.com/lib/pq"
 )
 
+// callers of the test requests are given by the api service, with the
+// token secret, which can grant any role
+func init() {
+	callerServices = map[string]CallerService{"api": {Token: secretDigest, Roles: []string{"reader", "editor", "admin"}}}
+}
+
 func setupTestSuite() {
 	db.Unscoped().Delete(Entity{})
 }
@@ -0,0 +1,257 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/jinzhu/gorm"
+)
+
+// anonymousActor : actor of the requests without a caller
+const anonymousActor = "anonymous"
+
+// adminRole : role of the callers that can access any datacenter
+const adminRole = "admin"
+
+// ownershipLock : advisory lock held while assigning the datacenters
+// without an owner, so replicas starting at once assign them only once
+const ownershipLock = 7405212
+
+// requireCaller : rejects requests without a caller once it's enabled,
+// otherwise they are served unrestricted
+var requireCaller bool
+
+// unownedOwner, unownedGroup : owner and group assigned on startup to the
+// datacenters stored before they had one, none are assigned if both are 0
+var unownedOwner, unownedGroup uint
+
+// CallerService : service allowed to make requests on behalf of callers,
+// identified by the sha256 hex digest of its token, along with the roles
+// it can grant them
+type CallerService struct {
+	Token string   `json:"token"`
+	Roles []string `json:"roles"`
+}
+
+// callerServices : services allowed to make requests on behalf of callers
+var callerServices = map[string]CallerService{}
+
+// loadCallerServices : loads the caller services from a json file with
+// the format {"<service>":{"token":"<sha256 of token>","roles":["reader"]}}
+func loadCallerServices(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	s := make(map[string]CallerService)
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	callerServices = s
+
+	return nil
+}
+
+// Caller : user performing a request through a service, datacenters are
+// only visible to their owner, the members of their group and admins.
+// The caller is only trusted if the token of its service is valid
+type Caller struct {
+	UserID   uint     `json:"user_id"`
+	GroupIDs []uint   `json:"group_ids"`
+	Roles    []string `json:"roles"`
+	Service  string   `json:"service"`
+	Token    string   `json:"token,omitempty"`
+}
+
+// callerOf : returns the caller of the request body once it's
+// authenticated, or nil if it has none
+func callerOf(body []byte) (*Caller, error) {
+	var input struct {
+		Caller *Caller `json:"caller"`
+	}
+
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, ErrInvalidInput
+	}
+	if input.Caller == nil {
+		return nil, nil
+	}
+
+	if err := input.Caller.authenticate(); err != nil {
+		return nil, err
+	}
+
+	return input.Caller, nil
+}
+
+// requiredCallerOf : returns the authenticated caller of the request
+// body, rejecting requests without one if callers are required
+func requiredCallerOf(body []byte) (*Caller, error) {
+	c, err := callerOf(body)
+	if err == nil && c == nil && requireCaller {
+		return nil, ErrUnauthorized
+	}
+	return c, err
+}
+
+// authenticate : verifies the caller is given by one of the caller
+// services, keeping only the roles its service can grant. Its token is
+// dropped once verified
+func (c *Caller) authenticate() error {
+	s, ok := callerServices[c.Service]
+	if !ok || !(ServiceIdentity{Service: c.Service, Token: c.Token}).verifies(s.Token) {
+		log.Println("Could not authenticate the caller of service " + c.Service)
+		return ErrUnauthenticated
+	}
+
+	roles := []string{}
+	for _, r := range c.Roles {
+		if !contains(s.Roles, r) {
+			log.Printf("Service %s can't grant the role %s to caller %d", c.Service, r, c.UserID)
+			continue
+		}
+		roles = append(roles, r)
+	}
+
+	c.Roles = roles
+	c.Token = ""
+
+	return nil
+}
+
+// isAdmin : determines if the caller can access any datacenter, requests
+// without a caller are only served unrestricted if callers are not
+// required
+func (c *Caller) isAdmin() bool {
+	return c == nil || contains(c.Roles, adminRole)
+}
+
+// actor : identifies the caller on the audit trail, the service is used
+// for requests it makes on its own behalf
+func (c *Caller) actor() string {
+	if c == nil {
+		return anonymousActor
+	}
+	if c.UserID == 0 {
+		return "service:" + c.Service
+	}
+	return fmt.Sprintf("user:%d", c.UserID)
+}
+
+// scope : restricts the query to the datacenters visible to the caller
+func (c *Caller) scope(q *gorm.DB) *gorm.DB {
+	if c.isAdmin() {
+		return q
+	}
+
+	if len(c.GroupIDs) > 0 {
+		return q.Where("owner_id = ? OR group_id in (?)", c.UserID, c.GroupIDs)
+	}
+
+	return q.Where("owner_id = ?", c.UserID)
+}
+
+// sees : determines if the caller can access a datacenter with the
+// given owner and group
+func (c *Caller) sees(owner, group uint) bool {
+	return c.isAdmin() || owner == c.UserID || containsID(c.GroupIDs, group)
+}
+
+// assign : sets the owner and group of a new datacenter, the caller
+// always owns it and can only assign it to one of its groups
+func (c *Caller) assign(e *Entity) error {
+	if c.isAdmin() {
+		return nil
+	}
+
+	e.OwnerID = c.UserID
+
+	if e.GroupID == 0 && len(c.GroupIDs) == 1 {
+		e.GroupID = c.GroupIDs[0]
+	}
+
+	if e.GroupID != 0 && !containsID(c.GroupIDs, e.GroupID) {
+		return &Error{Code: "403", Message: "Forbidden", Field: "group_id"}
+	}
+
+	return nil
+}
+
+func containsID(list []uint, id uint) bool {
+	for _, v := range list {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateOwnership : assigns the datacenters stored without an owner or a
+// group, by versions previous to the ownership, to the configured ones so
+// they're visible to their callers. Each assigned datacenter gets a new
+// version and is recorded as an update
+func migrateOwnership() error {
+	if unownedOwner == 0 && unownedGroup == 0 {
+		return nil
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := migrateOwnershipOn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func migrateOwnershipOn(tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", ownershipLock).Error; err != nil {
+		return err
+	}
+
+	var entities []Entity
+	if err := tx.Unscoped().Set("gorm:query_option", "FOR UPDATE").Where("owner_id = 0 AND group_id = 0").Order("id").Find(&entities).Error; err != nil {
+		return err
+	}
+
+	for i := range entities {
+		e := &entities[i]
+		before := *e
+
+		err := tx.Unscoped().Model(e).UpdateColumns(map[string]interface{}{
+			"owner_id": unownedOwner,
+			"group_id": unownedGroup,
+			"version":  gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		e.OwnerID = unownedOwner
+		e.GroupID = unownedGroup
+		e.Version++
+		e.Actor = systemActor
+		e.subject = "migration"
+		if err := e.record(tx, "update", &before, e, e.redacted()); err != nil {
+			return err
+		}
+	}
+
+	if len(entities) > 0 {
+		log.Printf("assigned %d datacenters without an owner to owner %d and group %d", len(entities), unownedOwner, unownedGroup)
+	}
+
+	return nil
+}
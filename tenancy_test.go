@@ -0,0 +1,120 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenancy(t *testing.T) {
+	setupNats()
+	defer n.Close()
+	_, _ = n.Subscribe("config.get.postgres", func(msg *nats.Msg) {
+		_ = n.Publish(msg.Reply, []byte(`{"names":["users","datacenters","datacenters","services"],"password":"","url":"postgres://postgres@127.0.0.1","user":""}`))
+	})
+	createTestDB("test_tenancy")
+	setupPg("test_tenancy")
+	startHandler()
+
+	find := func(caller string) []Entity {
+		list := []Entity{}
+		msg, _ := n.Request("datacenter.find", []byte(`{"caller":`+caller+`}`), time.Second)
+		So(json.Unmarshal(msg.Data, &list), ShouldBeNil)
+		return list
+	}
+
+	Convey("Scenario: scoping datacenters to their owners and groups", t, func() {
+		setupTestSuite()
+
+		msg, err := n.Request("datacenter.set", []byte(`{"name":"john-dc","type":"fake","owner_id":99,"caller":{"user_id":1,"group_ids":[10],"service":"api","token":"secret"}}`), time.Second)
+		So(err, ShouldBeNil)
+		owned := Entity{}
+		So(json.Unmarshal(msg.Data, &owned), ShouldBeNil)
+		So(owned.OwnerID, ShouldEqual, 1)
+		So(owned.GroupID, ShouldEqual, 10)
+
+		_, err = n.Request("datacenter.set", []byte(`{"name":"other-dc","type":"fake","caller":{"user_id":2,"group_ids":[20],"service":"api","token":"secret"}}`), time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("Given the caller owns or shares a group with datacenters", func() {
+			So(len(find(`{"user_id":1,"service":"api","token":"secret"}`)), ShouldEqual, 1)
+			So(len(find(`{"user_id":3,"group_ids":[10,20],"service":"api","token":"secret"}`)), ShouldEqual, 2)
+			So(len(find(`{"user_id":3,"group_ids":[30],"service":"api","token":"secret"}`)), ShouldEqual, 0)
+		})
+
+		Convey("Given the caller is an admin", func() {
+			So(len(find(`{"user_id":3,"roles":["admin"],"service":"api","token":"secret"}`)), ShouldEqual, 2)
+		})
+
+		Convey("Given the caller claims to be an admin through an unknown service", func() {
+			msg, err := n.Request("datacenter.find", []byte(`{"caller":{"user_id":3,"roles":["admin"],"service":"api","token":"forged"}}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrUnauthenticated.Encoded()))
+		})
+
+		Convey("Given callers are required and the request has none", func() {
+			requireCaller = true
+			defer func() { requireCaller = false }()
+
+			msg, err := n.Request("datacenter.find", []byte(`{}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrUnauthorized.Encoded()))
+		})
+
+		Convey("Given the caller queries the audit trail", func() {
+			msg, err := n.Request("datacenter.audit.find", []byte(`{"caller":{"user_id":1,"group_ids":[10],"service":"api","token":"secret"}}`), time.Second)
+			So(err, ShouldBeNil)
+			entries := []AuditEntry{}
+			So(json.Unmarshal(msg.Data, &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].DatacenterID, ShouldEqual, owned.ID)
+		})
+
+		Convey("Given datacenters stored before they had an owner", func() {
+			legacy := Entity{Name: "legacy-dc", Type: "fake"}
+			So(db.Create(&legacy).Error, ShouldBeNil)
+			So(len(find(`{"user_id":5,"group_ids":[50],"service":"api","token":"secret"}`)), ShouldEqual, 0)
+
+			Convey("When an owner and group are configured for them", func() {
+				unownedOwner, unownedGroup = 5, 50
+				defer func() { unownedOwner, unownedGroup = 0, 0 }()
+				So(migrateOwnership(), ShouldBeNil)
+
+				Convey("Then they are visible to their callers and audited", func() {
+					list := find(`{"user_id":6,"group_ids":[50],"service":"api","token":"secret"}`)
+					So(len(list), ShouldEqual, 1)
+					So(list[0].Name, ShouldEqual, "legacy-dc")
+					So(list[0].OwnerID, ShouldEqual, 5)
+
+					var entry AuditEntry
+					So(db.Where("datacenter_id = ?", legacy.ID).Last(&entry).Error, ShouldBeNil)
+					So(entry.Actor, ShouldEqual, systemActor)
+					So(entry.Operation, ShouldEqual, "update")
+				})
+			})
+		})
+
+		Convey("Given the caller gets a datacenter it can't see", func() {
+			msg, err := n.Request("datacenter.get", []byte(`{"id":`+fmt.Sprint(owned.ID)+`,"caller":{"user_id":2,"group_ids":[20],"service":"api","token":"secret"}}`), time.Second)
+			So(err, ShouldBeNil)
+			So(string(msg.Data), ShouldEqual, string(ErrNotFound.Encoded()))
+		})
+
+		Convey("Given the caller assigns a datacenter to a group it doesn't belong to", func() {
+			msg, err := n.Request("datacenter.set", []byte(`{"name":"sneaky-dc","type":"fake","group_id":20,"caller":{"user_id":1,"group_ids":[10],"service":"api","token":"secret"}}`), time.Second)
+			So(err, ShouldBeNil)
+			output := Error{}
+			So(json.Unmarshal(msg.Data, &output), ShouldBeNil)
+			So(output.Code, ShouldEqual, "403")
+			So(output.Field, ShouldEqual, "group_id")
+		})
+	})
+}
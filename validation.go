@@ -0,0 +1,160 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CredentialSchema : describes the credential keys accepted for a
+// datacenter type
+type CredentialSchema struct {
+	Required     []string
+	Optional     []string
+	Forbidden    []string
+	Formats      map[string]*regexp.Regexp
+	AllowUnknown bool
+}
+
+// FieldError : describes a single field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError : holds every field failing validation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error : returns the error string
+func (v *ValidationError) Error() string {
+	msgs := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return "invalid credentials: " + strings.Join(msgs, ", ")
+}
+
+var uuidFormat = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+var urlFormat = regexp.MustCompile(`^https?://[^\s]+$`)
+
+var schemas = map[string]*CredentialSchema{
+	"aws": {
+		Required:  []string{"region", "access_key_id", "secret_access_key"},
+		Forbidden: []string{"aws_access_token_id", "aws_access_key_id", "aws_secret_access_key"},
+		Formats: map[string]*regexp.Regexp{
+			"region": regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-[0-9]$`),
+		},
+	},
+	"azure": {
+		Required: []string{"subscription_id", "client_id", "client_secret", "tenant_id"},
+		Optional: []string{"environment"},
+		Formats: map[string]*regexp.Regexp{
+			"subscription_id": uuidFormat,
+			"client_id":       uuidFormat,
+			"tenant_id":       uuidFormat,
+		},
+	},
+	"vcloud": {
+		Required: []string{"username", "password", "vcloud_url"},
+		Optional: []string{"vdc", "external_network", "vse_url"},
+		Formats: map[string]*regexp.Regexp{
+			"vcloud_url": urlFormat,
+			"vse_url":    urlFormat,
+		},
+	},
+	"fake": {
+		AllowUnknown: true,
+	},
+}
+
+// unvalidatedTypes : datacenter types accepted without a credential
+// schema. The empty type is kept for clients creating datacenters before
+// types were introduced, any other type needs a registered schema
+var unvalidatedTypes = []string{""}
+
+// RegisterSchema : registers the credential schema for the given
+// datacenter type, replacing any existing one
+func RegisterSchema(t string, s *CredentialSchema) {
+	schemas[t] = s
+}
+
+// validateCredentials : validates the given credentials against the
+// schema registered for the datacenter type. Credentials already stored
+// for the datacenter are taken into account when checking required keys.
+// Types without a registered schema are rejected unless they're listed on
+// unvalidatedTypes
+func validateCredentials(t string, c Map, stored Map) error {
+	s, ok := schemas[t]
+	if !ok {
+		if contains(unvalidatedTypes, t) {
+			return nil
+		}
+		return ErrUnknownType
+	}
+
+	var fields []FieldError
+
+	for _, k := range s.Required {
+		if present(c, k) || present(stored, k) {
+			continue
+		}
+		fields = append(fields, FieldError{Field: "credentials." + k, Message: "is required"})
+	}
+
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch {
+		case contains(s.Forbidden, k):
+			fields = append(fields, FieldError{Field: "credentials." + k, Message: "is not allowed for type " + t})
+			continue
+		case !s.AllowUnknown && !contains(s.Required, k) && !contains(s.Optional, k):
+			fields = append(fields, FieldError{Field: "credentials." + k, Message: "is not a valid key for type " + t})
+			continue
+		}
+
+		f, ok := s.Formats[k]
+		if !ok {
+			continue
+		}
+		if v, ok := c[k].(string); !ok || !f.MatchString(v) {
+			fields = append(fields, FieldError{Field: "credentials." + k, Message: "has an invalid format"})
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
+func present(c Map, k string) bool {
+	v, ok := c[k]
+	if !ok || v == nil {
+		return false
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCredentialValidation(t *testing.T) {
+	Convey("Scenario: validating aws credentials", t, func() {
+		Convey("Given all required credentials are provided", func() {
+			err := validateCredentials("aws", Map{"region": "eu-west-1", "access_key_id": "id", "secret_access_key": "key"}, nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Given some credentials are missing, mistyped or invalid", func() {
+			err := validateCredentials("aws", Map{"region": "europe", "aws_access_token_id": "id", "foo": "bar"}, nil)
+			So(err, ShouldNotBeNil)
+
+			verr := err.(*ValidationError)
+			So(len(verr.Fields), ShouldEqual, 5)
+			So(verr.Fields[0].Field, ShouldEqual, "credentials.access_key_id")
+			So(verr.Fields[1].Field, ShouldEqual, "credentials.secret_access_key")
+			So(verr.Fields[2].Field, ShouldEqual, "credentials.aws_access_token_id")
+			So(verr.Fields[3].Field, ShouldEqual, "credentials.foo")
+			So(verr.Fields[4].Field, ShouldEqual, "credentials.region")
+		})
+
+		Convey("Given required credentials are already stored", func() {
+			stored := Map{"region": "eu-west-1", "access_key_id": "xxx", "secret_access_key": "xxx"}
+			err := validateCredentials("aws", Map{"access_key_id": "new-id"}, stored)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Scenario: validating credentials for a type without schema", t, func() {
+		Convey("Given an unknown type", func() {
+			So(validateCredentials("unknown", Map{"anything": "goes"}, nil), ShouldEqual, ErrUnknownType)
+			So(validateCredentials("AWS", Map{"region": "eu-west-1"}, nil), ShouldEqual, ErrUnknownType)
+		})
+
+		Convey("Given a datacenter without type", func() {
+			So(validateCredentials("", Map{"anything": "goes"}, nil), ShouldBeNil)
+		})
+	})
+
+	Convey("Scenario: registering a custom schema", t, func() {
+		RegisterSchema("custom", &CredentialSchema{Required: []string{"token"}})
+		defer delete(schemas, "custom")
+
+		So(validateCredentials("custom", Map{}, nil), ShouldNotBeNil)
+		So(validateCredentials("custom", Map{"token": "x"}, nil), ShouldBeNil)
+	})
+}
@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultKeyPrefix : prefix of the ids of keys managed by vault, followed
+// by the key version
+const vaultKeyPrefix = "vault."
+
+// VaultKeyProvider : wraps data keys through a HashiCorp Vault transit
+// compatible http api, so keys never leave it
+type VaultKeyProvider struct {
+	Address string
+	Token   string
+	Key     string
+	Client  *http.Client
+}
+
+// NewVaultKeyProvider : creates a provider for the given transit key
+func NewVaultKeyProvider(address, token, key string) *VaultKeyProvider {
+	return &VaultKeyProvider{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Key:     key,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// PrimaryID : returns the latest version of the transit key
+func (v *VaultKeyProvider) PrimaryID() (string, error) {
+	var res struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+
+	if err := v.call("GET", "/v1/transit/keys/"+v.Key, nil, &res); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%d", vaultKeyPrefix, res.Data.LatestVersion), nil
+}
+
+// Wrap : encrypts a data key with the latest version of the transit key
+func (v *VaultKeyProvider) Wrap(dataKey string) (string, string, error) {
+	var res struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(dataKey))}
+	if err := v.call("POST", "/v1/transit/encrypt/"+v.Key, req, &res); err != nil {
+		return "", "", err
+	}
+
+	// vault ciphertexts have the form vault:v<version>:<ciphertext>
+	parts := strings.SplitN(res.Data.Ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return "", "", errors.New("invalid vault ciphertext")
+	}
+
+	return vaultKeyPrefix + strings.TrimPrefix(parts[1], "v"), parts[2], nil
+}
+
+// Unwrap : decrypts a data key with the given version of the transit key
+func (v *VaultKeyProvider) Unwrap(id, wrapped string) (string, error) {
+	if !strings.HasPrefix(id, vaultKeyPrefix) {
+		return "", errors.New("unknown key " + id)
+	}
+
+	var res struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+
+	req := map[string]string{"ciphertext": "vault:v" + strings.TrimPrefix(id, vaultKeyPrefix) + ":" + wrapped}
+	if err := v.call("POST", "/v1/transit/decrypt/"+v.Key, req, &res); err != nil {
+		return "", err
+	}
+
+	plain, err := base64.StdEncoding.DecodeString(res.Data.Plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+func (v *VaultKeyProvider) call(method, path string, body interface{}, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, v.Address+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault replied with status %d on %s", resp.StatusCode, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}